@@ -0,0 +1,50 @@
+// Copyright 2012 by Graeme Humphries <graeme@sudo.ca>
+//
+// kdtree is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// kdtree is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with kdtree.  If not, see http://www.gnu.org/licenses/.
+
+package kdtree
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+/***** Access Tracking Functions *****/
+
+// Returns the time this node was last returned by a search, or added, in a Tree with TrackAccess
+// set, or the zero time if it never has been. Searches only hold the Tree's read lock, so the time
+// is stored atomically rather than in an exported field, and can be read while searches run.
+func (n *Node) LastAccess() time.Time {
+	if ns := atomic.LoadInt64(&n.lastAccess); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
+// Records that node n was accessed now, if the Tree tracks accesses. n may be nil.
+func (t *Tree) touch(n *Node) {
+	if t.TrackAccess && n != nil {
+		atomic.StoreInt64(&n.lastAccess, time.Now().UnixNano())
+	}
+}
+
+// Removes every node in the Tree whose LastAccess is before cutoff, including nodes never accessed,
+// returning the number of nodes removed, so a Tree with TrackAccess set can be used as a cache that
+// evicts its least recently used entries. The Tree is rebuilt from the remaining nodes in the same way
+// as RemoveMatching.
+func (t *Tree) EvictOlderThan(cutoff time.Time) int {
+	return t.RemoveMatching(func(n *Node) bool {
+		return n.LastAccess().Before(cutoff)
+	})
+}
@@ -0,0 +1,126 @@
+// Copyright 2012 by Graeme Humphries <graeme@sudo.ca>
+//
+// kdtree is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// kdtree is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with kdtree.  If not, see http://www.gnu.org/licenses/.
+
+package kdtree
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// Only nodes found, or added, since the cutoff should survive eviction. Concurrent searches record
+// accesses, so run with -race to check they're recorded safely.
+func TestEvictOlderThan(t *testing.T) {
+	nl := genlist(3, 1000)
+	tree := BuildTree(nl)
+	if _, err := tree.Find(nl[0].Coordinates); err != nil || !nl[0].LastAccess().IsZero() {
+		t.Fatal("A tree without TrackAccess set recorded an access.")
+	}
+
+	tree.TrackAccess = true
+	cutoff := time.Now()
+	var wg sync.WaitGroup
+	for _, n := range nl[:100] {
+		wg.Add(1)
+		go func(n *Node) {
+			defer wg.Done()
+			if found, _ := tree.Find(n.Coordinates); found != n {
+				t.Error(n.String() + " not found!")
+			}
+		}(n)
+	}
+	wg.Wait()
+	nearest, _, _ := tree.NearestNeighbor(rndCoords(3))
+	added := NewNode(rndCoords(3))
+	if err := tree.Add(added); err != nil {
+		t.Fatal(err)
+	}
+
+	kept := map[*Node]bool{nearest: true, added: true}
+	for _, n := range nl[:100] {
+		kept[n] = true
+	}
+	for n := range kept {
+		if n.LastAccess().Before(cutoff) {
+			t.Fatal(n.String()+" was last accessed at", n.LastAccess(), "before the cutoff", cutoff)
+		}
+	}
+	if evicted := tree.EvictOlderThan(cutoff); evicted != 1001-len(kept) {
+		t.Fatal("EvictOlderThan removed", evicted, "nodes, expected", 1001-len(kept))
+	}
+	if tree.Size() != len(kept) {
+		t.Fatal("Tree has", tree.Size(), "nodes after eviction, expected", len(kept))
+	}
+	for n := range kept {
+		if found, _ := tree.Find(n.Coordinates); found != n {
+			t.Fatal(n.String() + " was evicted.")
+		}
+	}
+}
+
+// Nodes added by AddAll or Merge should count as accessed, as nodes added by Add do, and survive
+// eviction.
+func TestAddAllAccess(t *testing.T) {
+	tree := BuildTree(genlist(3, 100))
+	tree.TrackAccess = true
+	cutoff := time.Now()
+	added, merged := genlist(3, 50), genlist(3, 50)
+	if err := tree.AddAll(added); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Merge(BuildTree(merged)); err != nil {
+		t.Fatal(err)
+	}
+	if evicted := tree.EvictOlderThan(cutoff); evicted != 100 {
+		t.Fatal("EvictOlderThan removed", evicted, "nodes, expected 100")
+	}
+	for _, n := range append(added, merged...) {
+		if found, _ := tree.Find(n.Coordinates); found != n {
+			t.Fatal(n.String() + " was evicted.")
+		}
+	}
+}
+
+// Clones taken while searches are recording accesses should copy each node's last access safely.
+// Run with -race to check.
+func TestCloneAccess(t *testing.T) {
+	nl := genlist(3, 1000)
+	tree := BuildTree(nl)
+	tree.TrackAccess = true
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for _, n := range nl {
+				tree.Find(n.Coordinates)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			tree.Clone()
+		}()
+	}
+	wg.Wait()
+
+	// clones keep the tree's shape, so list their nodes in the same order
+	copies := tree.Clone().NodeList()
+	for i, n := range tree.NodeList() {
+		if !copies[i].LastAccess().Equal(n.LastAccess()) {
+			t.Fatal("Clone of " + n.String() + " doesn't have its last access.")
+		}
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2012 by Graeme Humphries <graeme@sudo.ca>
+//
+// kdtree is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// kdtree is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with kdtree.  If not, see http://www.gnu.org/licenses/.
+
+package kdtree
+
+import (
+	"reflect"
+	"sort"
+)
+
+/***** Tree Comparison Functions *****/
+
+// Tests whether the Tree and other contain the same nodes, comparing each node's coordinates,
+// Index and Value, regardless of how the trees are structured. Values are compared with
+// reflect.DeepEqual. Both trees are read locked during the comparison.
+func (t *Tree) Equal(other *Tree) bool {
+	if t == other {
+		return true
+	}
+	first, second := lockOrder(t, other)
+	first.Mutex.RLock()
+	defer first.Mutex.RUnlock()
+	second.Mutex.RLock()
+	defer second.Mutex.RUnlock()
+
+	a := sortedNodes(t.Root.nodeListInto(make([]*Node, 0, t.count)))
+	b := sortedNodes(other.Root.nodeListInto(make([]*Node, 0, other.count)))
+	if len(a) != len(b) {
+		return false
+	}
+	for start := 0; start < len(a); {
+		// nodes with the same coordinates and Index can be in any order, so match their Values as a group
+		end := start + 1
+		for end < len(a) && compareNodes(a[start], a[end]) == 0 {
+			end++
+		}
+		if compareNodes(a[start], b[start]) != 0 || compareNodes(a[end-1], b[end-1]) != 0 || !sameValues(a[start:end], b[start:end]) {
+			return false
+		}
+		start = end
+	}
+	return true
+}
+
+// Tests whether the Tree and other have exactly the same structure: every node must have the same
+// coordinates, axis, Index and Value as the node in the same position in the other tree. Values are
+// compared with reflect.DeepEqual. Both trees are read locked during the comparison.
+func (t *Tree) EqualStructure(other *Tree) bool {
+	if t == other {
+		return true
+	}
+	first, second := lockOrder(t, other)
+	first.Mutex.RLock()
+	defer first.Mutex.RUnlock()
+	second.Mutex.RLock()
+	defer second.Mutex.RUnlock()
+	return t.Root.equalStructure(other.Root)
+}
+
+// Tests whether this (sub)tree has exactly the same structure as o.
+func (n *Node) equalStructure(o *Node) bool {
+	if n == nil || o == nil {
+		return n == o
+	}
+	if len(n.bucket) != len(o.bucket) {
+		return false
+	}
+	for i, b := range n.bucket {
+		if !b.equalStructure(o.bucket[i]) {
+			return false
+		}
+	}
+	return n.axis == o.axis && n.Index == o.Index && equal_fl(n.Coordinates, o.Coordinates) &&
+		reflect.DeepEqual(n.Value, o.Value) &&
+		n.leftChild.equalStructure(o.leftChild) && n.rightChild.equalStructure(o.rightChild)
+}
+
+// Sorts a list of nodes by coordinates, then Index, returning the list.
+func sortedNodes(nodes []*Node) []*Node {
+	sort.Slice(nodes, func(i, j int) bool {
+		return compareNodes(nodes[i], nodes[j]) < 0
+	})
+	return nodes
+}
+
+// Compares two nodes by their coordinates, axis by axis, then by Index, returning -1, 0 or 1.
+func compareNodes(a, b *Node) int {
+	for i := 0; i < len(a.Coordinates) && i < len(b.Coordinates); i++ {
+		if a.Coordinates[i] < b.Coordinates[i] {
+			return -1
+		} else if a.Coordinates[i] > b.Coordinates[i] {
+			return 1
+		}
+	}
+	switch {
+	case len(a.Coordinates) != len(b.Coordinates):
+		if len(a.Coordinates) < len(b.Coordinates) {
+			return -1
+		}
+		return 1
+	case a.Index < b.Index:
+		return -1
+	case a.Index > b.Index:
+		return 1
+	}
+	return 0
+}
+
+// Tests whether two equally long lists of nodes hold the same Values in any order.
+func sameValues(a, b []*Node) bool {
+	used := make([]bool, len(b))
+	for _, n := range a {
+		found := false
+		for i, o := range b {
+			if !used[i] && reflect.DeepEqual(n.Value, o.Value) {
+				used[i], found = true, true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
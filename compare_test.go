@@ -0,0 +1,82 @@
+// Copyright 2012 by Graeme Humphries <graeme@sudo.ca>
+//
+// kdtree is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// kdtree is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with kdtree.  If not, see http://www.gnu.org/licenses/.
+
+package kdtree
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+)
+
+func TestEqual(t *testing.T) {
+	nl := genlist(4, 5000)
+	for i, n := range nl {
+		n.Index = i % 100
+		n.Value = []int{i % 7}
+	}
+	tree := BuildTree(nl)
+
+	clone := tree.Clone()
+	if !tree.Equal(clone) || !tree.EqualStructure(clone) {
+		t.Fatal("Tree should equal its clone.")
+	}
+
+	// the same nodes added one at a time give a differently structured, but equal, tree
+	added := new(Tree)
+	for _, n := range nl {
+		c := NewNodeWithValue(append([]float64(nil), n.Coordinates...), []int{n.Value.([]int)[0]})
+		c.Index = n.Index
+		if err := added.Add(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !tree.Equal(added) {
+		t.Fatal("Trees with the same nodes should be equal.")
+	}
+	if tree.EqualStructure(added) {
+		t.Fatal("Trees with different structures should not be structurally equal.")
+	}
+
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(tree); err != nil {
+		t.Fatal(err)
+	}
+	decoded := new(Tree)
+	if err := gob.NewDecoder(buf).Decode(decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !tree.EqualStructure(decoded) {
+		t.Fatal("Decoded tree should have the same structure as the original.")
+	}
+
+	clone.Root.leftChild.Value = []int{-1}
+	if tree.Equal(clone) || tree.EqualStructure(clone) {
+		t.Fatal("Trees with different Values should not be equal.")
+	}
+	clone = tree.Clone()
+	clone.Root.rightChild.Index++
+	if tree.Equal(clone) {
+		t.Fatal("Trees with different Indexes should not be equal.")
+	}
+	clone = tree.Clone()
+	clone.Remove(clone.Root)
+	if tree.Equal(clone) || clone.Equal(tree) {
+		t.Fatal("Trees with different numbers of nodes should not be equal.")
+	}
+	if !tree.Equal(tree) {
+		t.Fatal("A tree should equal itself.")
+	}
+}
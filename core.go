@@ -29,24 +29,46 @@ import (
 
 // Tree node, can be the parent for a subtree.
 type Node struct {
-	Fare uint16 // index from original data structure
+	// Time this node was last found or added by a Tree with TrackAccess set, in nanoseconds since the
+	// Unix epoch, or 0 until then. First, so it's 64-bit aligned for atomic access on 32-bit platforms.
+	lastAccess int64
+
+	Index int         // index from original data structure
+	Value interface{} // user data associated with this node's coordinates
 
 	// Axis for plane of bisection for this node, determined when added to a tree.
 	axis        int
-	Coordinates [4]float64
+	Coordinates []float64
 	leftChild   *Node // Nodes < Location on this axis.
 	rightChild  *Node // Nodes >= Location on this axis.
+	parent      *Node // nil for the root of a tree.
+
+	// Height of the subtree rooted at this node, 1 for a leaf, kept up to date by Tree operations.
+	height int
+
+	// In trees built by BuildTreeBucket, further nodes in this node's region of the tree, which
+	// are searched along with it rather than split by its axis. Their parent is this node.
+	bucket []*Node
 }
 
-// Create a new node from a set of coordinates.
-func NewNode(coords [4]float64) *Node {
+// Create a new node from a set of coordinates. The node has len(coords) dimensions,
+// and keeps coords rather than copying it.
+func NewNode(coords []float64) *Node {
 	n := new(Node)
 	n.Coordinates = coords
 
 	return n
 }
 
-func String(list [4]float64) string {
+// Create a new node from a set of coordinates, carrying v as its Value.
+func NewNodeWithValue(coords []float64, v interface{}) *Node {
+	n := NewNode(coords)
+	n.Value = v
+
+	return n
+}
+
+func String(list []float64) string {
 	out := "("
 	for i := 0; i < len(list); i++ {
 		out += " " + strconv.FormatFloat(list[i], 'G', 5, 64)
@@ -61,7 +83,42 @@ func (n *Node) String() string {
 	return out
 }
 
+// Returns the axis this node splits its subtree on. Nodes in a bucket don't split anything, so
+// their axis has no meaning.
+func (n *Node) Axis() int {
+	return n.axis
+}
+
+// Returns the root of the tree this node is part of, by following parent links. This loops
+// rather than recursing, so pathologically deep trees can't overflow the stack.
+func (n *Node) root() *Node {
+	for n.parent != nil {
+		n = n.parent
+	}
+	return n
+}
+
+// Tests whether this node is in its parent's bucket, rather than being one of its children.
+func (n *Node) inBucket() bool {
+	return n.parent != nil && n.parent.leftChild != n && n.parent.rightChild != n
+}
+
+// Performs the same traversal as traverse, also passing f each node's depth below this node, which
+// is at depth. Nodes in a bucket are one level deeper than the node holding them.
+func (n *Node) traverseDepth(depth int, f func(*Node, int)) {
+	if n == nil {
+		return
+	}
+	n.leftChild.traverseDepth(depth+1, f)
+	n.rightChild.traverseDepth(depth+1, f)
+	for _, b := range n.bucket {
+		f(b, depth+1)
+	}
+	f(n, depth)
+}
+
 // Performs a left depth first tree traversal, running function f on every Node found.
+// Nodes are visited in post-order: left subtree, right subtree, the node's bucket, then the node itself.
 func (n *Node) traverse(f func(*Node)) {
 	if n != nil {
 		if n.leftChild != nil {
@@ -70,6 +127,9 @@ func (n *Node) traverse(f func(*Node)) {
 		if n.rightChild != nil {
 			n.rightChild.traverse(f)
 		}
+		for _, b := range n.bucket {
+			f(b)
+		}
 		f(n)
 	}
 }
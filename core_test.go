@@ -16,8 +16,13 @@
 package kdtree
 
 import (
+	"context"
+	"math"
 	"math/rand"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -53,10 +58,16 @@ func genlist(dimensions, size int) []*Node {
 func TestBuildTree(t *testing.T) {
 	// test size: 6 dimensions == 2 * normal 3d, 100000 nodes == "5 9s" of accuracy.
 	nl := genlist(6, 100000)
+	order := append([]*Node(nil), nl...)
 	tree := BuildTree(nl)
 	if tree == nil {
 		t.Fatal("Tree not generated!")
 	}
+	for i := range nl {
+		if nl[i] != order[i] {
+			t.Fatal("BuildTree reordered the node list at index", i)
+		}
+	}
 	if err := tree.Validate(); err != nil {
 		t.Fatal("Tree is not valid: " + err.Error())
 	}
@@ -71,6 +82,362 @@ func TestBuildTree(t *testing.T) {
 	}
 }
 
+// Nodes with many coordinates in common, some only told apart by their Index, should build the
+// same tree whatever order they're listed in.
+func TestBuildTreeDeterministic(t *testing.T) {
+	nl := make([]*Node, 5000)
+	for i := range nl {
+		nl[i] = NewNode([]float64{float64(rand.Intn(10)), float64(rand.Intn(10)), float64(rand.Intn(3))})
+		nl[i].Index = rand.Intn(2)
+	}
+	shuffled := func() []*Node {
+		copies := make([]*Node, len(nl))
+		for i, v := range rand.Perm(len(nl)) {
+			copies[i] = NewNode(append([]float64(nil), nl[v].Coordinates...))
+			copies[i].Index = nl[v].Index
+		}
+		return copies
+	}
+
+	for _, leafSize := range []int{1, 8} {
+		first := BuildTreeBucket(shuffled(), leafSize)
+		for i := 0; i < 5; i++ {
+			if tree := BuildTreeBucket(shuffled(), leafSize); !tree.EqualStructure(first) {
+				t.Fatal("Building the same nodes in a different order built a different tree, with leafSize", leafSize)
+			}
+		}
+		if err := first.Validate(); err != nil {
+			t.Fatal("Tree is not valid: " + err.Error())
+		}
+	}
+}
+
+// Node values should be untouched by building and balancing a tree.
+func TestNodeValue(t *testing.T) {
+	nl := make([]*Node, 1000)
+	for i := range nl {
+		nl[i] = NewNodeWithValue(rndCoords(6), i)
+	}
+	tree := BuildTree(nl)
+	tree.Balance()
+	for k, n := range nl {
+		search, err := tree.Find(n.Coordinates)
+		if err != nil || search == nil {
+			t.Fatal(strconv.FormatInt(int64(k), 10) + ": " + n.String() + " not found!")
+		}
+		if v, ok := search.Value.(int); !ok || v != k {
+			t.Fatal(strconv.FormatInt(int64(k), 10)+": "+n.String()+" has the wrong value:", search.Value)
+		}
+	}
+}
+
+// Building with a low parallel threshold should produce a valid tree containing every node.
+func TestBuildTreeParallel(t *testing.T) {
+	defer func(threshold int) {
+		ParallelBuildThreshold = threshold
+	}(ParallelBuildThreshold)
+	ParallelBuildThreshold = 16
+
+	nl := genlist(6, 100000)
+	tree := BuildTree(nl)
+	if err := tree.Validate(); err != nil {
+		t.Fatal("Tree is not valid: " + err.Error())
+	}
+	for k, n := range nl {
+		if search, err := tree.Find(n.Coordinates); err != nil || search != n {
+			t.Fatal(strconv.FormatInt(int64(k), 10) + ": " + n.String() + " not found!")
+		}
+	}
+}
+
+// A tree built without parent links should search normally, stay without them when rebuilt, and
+// refuse changes which need them.
+func TestBuildTreeNoParent(t *testing.T) {
+	nl := genlist(3, 5000)
+	tree := BuildTreeNoParent(nl)
+	checkNoParents := func() {
+		if err := tree.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		tree.Traverse(func(n *Node) {
+			if n.parent != nil {
+				t.Fatal(n.String() + " has a parent.")
+			}
+		})
+	}
+	checkNoParents()
+	for _, n := range nl {
+		if found, _ := tree.Find(n.Coordinates); found != n {
+			t.Fatal(n.String() + " not found!")
+		}
+	}
+
+	n := tree.Root.leftChild
+	if err := tree.Remove(n); err != ErrNoParents {
+		t.Fatal("Remove should return ErrNoParents, got", err)
+	}
+	if _, err := tree.RemoveAt(n.Coordinates); err != ErrNoParents {
+		t.Fatal("RemoveAt should return ErrNoParents, got", err)
+	}
+	if err := tree.Move(n, rndCoords(3)); err != ErrNoParents {
+		t.Fatal("Move should return ErrNoParents, got", err)
+	}
+	if _, err := tree.Detach(n); err != ErrNoParents {
+		t.Fatal("Detach should return ErrNoParents, got", err)
+	}
+	if err := tree.Add(NewNode(rndCoords(3))); err != ErrNoParents {
+		t.Fatal("Add should return ErrNoParents, got", err)
+	}
+	if _, _, err := tree.AddUnique(NewNode(rndCoords(3))); err != ErrNoParents {
+		t.Fatal("AddUnique should return ErrNoParents, got", err)
+	}
+	if tree.Size() != 5000 {
+		t.Fatal("Tree has", tree.Size(), "nodes after refused changes, expected 5000")
+	}
+	checkNoParents()
+
+	if err := tree.AddAll(genlist(3, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if tree.RemoveMatching(func(n *Node) bool { return n.Coordinates[0] < 0.5 }) == 0 {
+		t.Fatal("RemoveMatching removed no nodes.")
+	}
+	checkNoParents()
+	tree = tree.Clone()
+	checkNoParents()
+}
+
+// BuildTreeChecked should build the same tree as BuildTree from valid nodes, and return an error
+// rather than panicking for invalid ones.
+func TestBuildTreeChecked(t *testing.T) {
+	tree, err := BuildTreeChecked(genlist(3, 1000))
+	if err != nil {
+		t.Fatal("Error while building tree:", err)
+	}
+	if err := tree.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if tree.Size() != 1000 {
+		t.Fatal("Tree has", tree.Size(), "nodes, expected 1000")
+	}
+
+	tests := []struct {
+		nodes    []*Node
+		expected string
+	}{
+		{[]*Node{NewNode([]float64{1, 2}), nil}, "Node 1 is nil."},
+		{[]*Node{NewNode([]float64{}), NewNode([]float64{})}, "Node 0 has no coordinates."},
+		{[]*Node{NewNode([]float64{1, 2}), NewNode([]float64{1, 2, 3})}, "Node 1 has 3 dimensions, node 0 has 2."},
+		{[]*Node{NewNode([]float64{1, 2}), NewNode([]float64{1, math.NaN()})}, "Node 1 has a NaN coordinate on axis 1."},
+	}
+	for _, test := range tests {
+		if tree, err := BuildTreeChecked(test.nodes); tree != nil || err == nil || err.Error() != test.expected {
+			t.Fatal("BuildTreeChecked returned error", err, "expected", test.expected)
+		}
+	}
+}
+
+// A tree with buckets should be shallower than one without, and searches and removals should
+// find nodes in buckets as well as in the tree.
+func TestBuildTreeBucket(t *testing.T) {
+	nl := genlist(3, 5000)
+	tree := BuildTreeBucket(nl, 8)
+	if err := tree.Validate(); err != nil {
+		t.Fatal("Tree is not valid: " + err.Error())
+	}
+	if size := tree.Size(); size != len(nl) {
+		t.Fatal("Tree has Size", size, "expected", len(nl))
+	}
+	if depth, plain := tree.Depth(), BuildTree(genlist(3, 5000)).Depth(); depth >= plain {
+		t.Fatal("Bucket tree has depth", depth, "expected less than", plain)
+	}
+	for k, n := range nl {
+		if search, err := tree.Find(n.Coordinates); err != nil || search != n {
+			t.Fatal(strconv.FormatInt(int64(k), 10) + ": " + n.String() + " not found!")
+		}
+	}
+
+	for i := 0; i < 200; i++ {
+		coords := rndCoords(3)
+		n, dist, err := tree.NearestNeighbor(coords)
+		if err != nil {
+			t.Fatal("Error while searching tree:", err)
+		}
+		if expected, expectedDist := bruteNearest(nl, coords, EuclideanMetric{}); n != expected && dist != expectedDist {
+			t.Fatal("Nearest to " + String(coords) + " should be " + expected.String() + ", found " + n.String())
+		}
+
+		ranges := map[int]Range{rand.Intn(3): {coords[0] - 0.2, coords[0] + 0.2}}
+		results, err := tree.FindRange(ranges)
+		if err != nil {
+			t.Fatal(err)
+		}
+		snl := sortableNodeList{0, nl}
+		expected, _ := snl.findrange(ranges)
+		if len(results) != len(expected) {
+			t.Fatal("Tree FindRange returned", len(results), "nodes, list findrange returned", len(expected))
+		}
+	}
+
+	// removing nodes, including ones in buckets and leaves holding buckets, keeps the tree valid
+	for _, n := range nl[:2500] {
+		if err := tree.Remove(n); err != nil {
+			t.Fatal("Failed to remove " + n.String() + ": " + err.Error())
+		}
+	}
+	if err := tree.Validate(); err != nil {
+		t.Fatal("Tree is not valid after removals: " + err.Error())
+	}
+	for _, n := range nl[2500:] {
+		if search, err := tree.Find(n.Coordinates); err != nil || search != n {
+			t.Fatal(n.String() + " not found after removals!")
+		}
+	}
+	if size := len(tree.NodeList()); size != 2500 {
+		t.Fatal("Tree holds", size, "nodes after removals, expected 2500")
+	}
+}
+
+// Trees built with an axis strategy should split where it chooses, keep it when rebalanced,
+// and still be searchable.
+func TestBuildTreeStrategy(t *testing.T) {
+	// spread far more widely on axis 1 than on axes 0 and 2
+	nl := genlist(3, 5000)
+	for _, n := range nl {
+		n.Coordinates[1] *= 1000
+	}
+	if axis := MaxVarianceAxis(nl, 0); axis != 1 {
+		t.Fatal("MaxVarianceAxis chose axis", axis, "expected 1")
+	}
+
+	tree := BuildTreeStrategy(nl, MaxVarianceAxis)
+	if err := tree.Validate(); err != nil {
+		t.Fatal("Tree is not valid: " + err.Error())
+	}
+	if tree.Root.axis != 1 {
+		t.Fatal("Root splits on axis", tree.Root.axis, "expected 1")
+	}
+	for k, n := range nl {
+		if search, err := tree.Find(n.Coordinates); err != nil || search != n {
+			t.Fatal(strconv.FormatInt(int64(k), 10) + ": " + n.String() + " not found!")
+		}
+	}
+	ranges := map[int]Range{1: {200, 400}}
+	results, _ := tree.FindRange(ranges)
+	snl := sortableNodeList{0, nl}
+	if expected, _ := snl.findrange(ranges); len(results) != len(expected) {
+		t.Fatal("Tree FindRange returned", len(results), "nodes, list findrange returned", len(expected))
+	}
+
+	last := func(nodes []*Node, depth int) int {
+		return len(nodes[0].Coordinates) - 1
+	}
+	tree = BuildTreeStrategy(nl, last)
+	tree.Balance()
+	tree.Traverse(func(n *Node) {
+		if n.axis != 2 {
+			t.Fatal(n.String() + " should split on axis 2 after balancing.")
+		}
+	})
+	if err := tree.Validate(); err != nil {
+		t.Fatal("Tree is not valid: " + err.Error())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("A strategy returning an axis outside of the tree's dimensions should panic.")
+		}
+	}()
+	BuildTreeStrategy(nl, func(nodes []*Node, depth int) int {
+		return 3
+	})
+}
+
+// selectNth should split a list around the value that sorting would put at index k, returning
+// the first index holding that value, even when many nodes share values.
+func TestSelectNth(t *testing.T) {
+	for _, grid := range []int{2, 10, 1000} {
+		nl := make([]*Node, 500)
+		for i := range nl {
+			nl[i] = NewNode([]float64{float64(rand.Intn(grid))})
+		}
+		sorted := make([]float64, len(nl))
+		for i, n := range nl {
+			sorted[i] = n.Coordinates[0]
+		}
+		sort.Float64s(sorted)
+
+		for k := range nl {
+			snl := sortableNodeList{0, append([]*Node(nil), nl...)}
+			i := snl.selectNth(k, nil)
+			v := sorted[k]
+			if i > k || snl.Nodes[i].Coordinates[0] != v || (i > 0 && sorted[i-1] == v) {
+				t.Fatal("selectNth", k, "returned index", i, "with value", snl.Nodes[i].Coordinates[0], "expected first index of", v)
+			}
+			for j, n := range snl.Nodes {
+				if (j < i && n.Coordinates[0] >= v) || (j > i && n.Coordinates[0] < v) {
+					t.Fatal("selectNth", k, "left", n.Coordinates[0], "at index", j, "on the wrong side of", v)
+				}
+			}
+		}
+	}
+}
+
+func TestDimensionMismatch(t *testing.T) {
+	func() {
+		defer func() {
+			if r := recover(); r != ErrDimensionMismatch {
+				t.Fatal("Building a tree from nodes with differing dimensions should panic with ErrDimensionMismatch, got", r)
+			}
+		}()
+		BuildTree(append(genlist(6, 100), NewNode(rndCoords(3))))
+	}()
+
+	tree := BuildTree(genlist(6, 100))
+	if err := tree.Add(NewNode(rndCoords(3))); err != ErrDimensionMismatch {
+		t.Fatal("Adding a node with the wrong dimensions should return ErrDimensionMismatch, got", err)
+	}
+	if err := tree.Validate(); err != nil {
+		t.Fatal("Tree is not valid after a failed Add: " + err.Error())
+	}
+	tree.Root.leftChild.Coordinates = rndCoords(3)
+	if err := tree.Validate(); err != ErrDimensionMismatch {
+		t.Fatal("Validating a tree with nodes of differing dimensions should return ErrDimensionMismatch, got", err)
+	}
+}
+
+// Nodes with NaN coordinates should be rejected rather than added where they can't be found.
+func TestNaNCoordinate(t *testing.T) {
+	tree := BuildTree(genlist(3, 100))
+	nan := []float64{0.5, math.NaN(), 0.5}
+	if err := tree.Add(NewNode(nan)); err != ErrNaNCoordinate {
+		t.Fatal("Adding a node with a NaN coordinate should return ErrNaNCoordinate, got", err)
+	}
+	if err := new(Tree).Add(NewNode(nan)); err != ErrNaNCoordinate {
+		t.Fatal("Adding a node with a NaN coordinate to an empty tree should return ErrNaNCoordinate, got", err)
+	}
+	if _, _, err := tree.AddUnique(NewNode(nan)); err != ErrNaNCoordinate {
+		t.Fatal("Adding a unique node with a NaN coordinate should return ErrNaNCoordinate, got", err)
+	}
+	if err := tree.AddAll([]*Node{NewNode(rndCoords(3)), NewNode(nan)}); err != ErrNaNCoordinate {
+		t.Fatal("Adding nodes with a NaN coordinate should return ErrNaNCoordinate, got", err)
+	}
+	n := tree.Root.leftChild
+	coords := n.Coordinates
+	if err := tree.Move(n, nan); err != ErrNaNCoordinate {
+		t.Fatal("Moving a node to a NaN coordinate should return ErrNaNCoordinate, got", err)
+	}
+	if !equal_fl(n.Coordinates, coords) {
+		t.Fatal("Node moved to", String(n.Coordinates), "after a failed Move.")
+	}
+	if tree.Size() != 100 {
+		t.Fatal("Tree has", tree.Size(), "nodes after rejecting NaN coordinates, expected 100")
+	}
+	if err := tree.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // Test speed to create a new tree from randomly generated nodes.
 func BenchmarkBuildTree(b *testing.B) {
 	// We're benchmarking tree generation, not node list generation, pause until
@@ -82,6 +449,253 @@ func BenchmarkBuildTree(b *testing.B) {
 	BuildTree(nl)
 }
 
+// Traverse should visit every node in the tree once, children before their parents.
+// Validate should catch nodes misplaced relative to any ancestor, not just their parent,
+// and broken parent links.
+func TestValidate(t *testing.T) {
+	root := NewNode([]float64{5, 5})
+	tree := BuildTree([]*Node{root})
+	left := NewNode([]float64{3, 5})
+	grandchild := NewNode([]float64{6, 7})
+	for _, n := range []*Node{left, grandchild} {
+		if err := tree.Add(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Validate(); err != nil {
+		t.Fatal("Tree is not valid: " + err.Error())
+	}
+
+	// move grandchild under left, where it's only checked against root on axis 0
+	tree.Root.rightChild = nil
+	left.rightChild = grandchild
+	grandchild.parent = left
+	err := tree.Validate()
+	if err == nil {
+		t.Fatal("Validate should fail when a node is on the wrong side of its grandparent.")
+	}
+	if !strings.Contains(err.Error(), "axis 0") {
+		t.Fatal("Validate error should name the axis checked:", err)
+	}
+
+	left.rightChild = nil
+	grandchild.parent = nil
+	if err := tree.Validate(); err != nil {
+		t.Fatal("Tree is not valid: " + err.Error())
+	}
+	left.parent = grandchild
+	if err := tree.Validate(); err == nil {
+		t.Fatal("Validate should fail when a node's parent link is wrong.")
+	}
+}
+
+// Moving any node's coordinates in place across an ancestor's splitting plane should be caught,
+// naming the node and the coordinate that's out of place.
+func TestValidateMutation(t *testing.T) {
+	nl := genlist(3, 1000)
+	tree := BuildTree(nl)
+	for _, n := range nl {
+		p := n.parent
+		if p == nil {
+			continue
+		}
+		c := n.Coordinates[p.axis]
+		if p.leftChild == n {
+			n.Coordinates[p.axis] = p.Coordinates[p.axis]
+		} else {
+			n.Coordinates[p.axis] = math.Nextafter(p.Coordinates[p.axis], math.Inf(-1))
+		}
+		err := tree.Validate()
+		if err == nil {
+			t.Fatal("Validate should fail when " + n.String() + " is on the wrong side of its parent.")
+		}
+		value := strconv.FormatFloat(n.Coordinates[p.axis], 'G', -1, 64)
+		if !strings.Contains(err.Error(), n.String()) || !strings.Contains(err.Error(), value) {
+			t.Fatal("Validate error should name", n.String(), "and its coordinate", value+":", err)
+		}
+		n.Coordinates[p.axis] = c
+	}
+	if err := tree.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Broken parent links should all be repaired, and links in a tree built without them cleared.
+func TestFixParents(t *testing.T) {
+	tree := BuildTreeBucket(genlist(3, 1000), 4)
+	if fixed := tree.FixParents(); fixed != 0 {
+		t.Fatal("FixParents changed", fixed, "links in a new tree.")
+	}
+	nl := tree.NodeList()
+	for _, n := range nl[:100] {
+		n.parent = nil
+	}
+	for _, n := range nl[100:200] {
+		n.parent = nl[0]
+	}
+	tree.Root.parent = nl[1]
+	if err := tree.Validate(); err == nil {
+		t.Fatal("Validate should fail with broken parent links.")
+	}
+	if fixed := tree.FixParents(); fixed < 190 || fixed > 201 {
+		t.Fatal("FixParents changed", fixed, "links, expected about 200")
+	}
+	if err := tree.Validate(); err != nil {
+		t.Fatal("Tree is not valid after fixing parents: " + err.Error())
+	}
+	for _, n := range nl[:200] {
+		if err := tree.Remove(n); err != nil {
+			t.Fatal("Failed to remove node " + n.String() + ": " + err.Error())
+		}
+	}
+
+	tree = BuildTreeNoParent(genlist(3, 1000))
+	tree.Root.leftChild.parent = tree.Root
+	if fixed := tree.FixParents(); fixed != 1 {
+		t.Fatal("FixParents changed", fixed, "links in a tree without parents, expected 1")
+	}
+	if err := tree.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTraverse(t *testing.T) {
+	nl := genlist(6, 10000)
+	tree := BuildTree(nl)
+	visited := make(map[*Node]bool)
+	tree.Traverse(func(n *Node) {
+		if visited[n] {
+			t.Fatal(n.String() + " visited twice.")
+		}
+		if (n.leftChild != nil && !visited[n.leftChild]) || (n.rightChild != nil && !visited[n.rightChild]) {
+			t.Fatal(n.String() + " visited before its children.")
+		}
+		visited[n] = true
+	})
+	if len(visited) != tree.Size() {
+		t.Fatal("Traverse visited", len(visited), "nodes, tree has", tree.Size())
+	}
+}
+
+// Every node's depth should be the number of parent links above it, and its axis should cycle
+// with depth.
+func TestTraverseDepth(t *testing.T) {
+	for _, tree := range []*Tree{BuildTree(genlist(3, 10000)), BuildTreeBucket(genlist(3, 10000), 8)} {
+		count := 0
+		tree.TraverseDepth(func(n *Node, depth int) {
+			count++
+			parents := 0
+			for p := n.parent; p != nil; p = p.parent {
+				parents++
+			}
+			if depth != parents {
+				t.Fatal(n.String()+" visited at depth", depth, "but has", parents, "parents")
+			}
+			if !n.inBucket() && n.Axis() != depth%3 {
+				t.Fatal(n.String()+" at depth", depth, "splits on axis", n.Axis())
+			}
+		})
+		if count != tree.Size() {
+			t.Fatal("TraverseDepth visited", count, "nodes, tree has", tree.Size())
+		}
+	}
+}
+
+func TestEach(t *testing.T) {
+	tree := BuildTree(genlist(6, 10000))
+	count := 0
+	tree.Each(func(n *Node) bool {
+		count++
+		return true
+	})
+	if count != tree.Size() {
+		t.Fatal("Each visited", count, "nodes, tree has", tree.Size())
+	}
+
+	count = 0
+	tree.Each(func(n *Node) bool {
+		count++
+		return count < 10
+	})
+	if count != 10 {
+		t.Fatal("Each visited", count, "nodes after being stopped at 10.")
+	}
+}
+
+// A panic in a Traverse or Each callback should release the tree's lock, so later writes
+// don't deadlock.
+func TestTraversePanic(t *testing.T) {
+	tree := BuildTree(genlist(3, 100))
+	for _, traverse := range []func(){
+		func() { tree.Traverse(func(*Node) { panic("callback") }) },
+		func() { tree.Each(func(*Node) bool { panic("callback") }) },
+	} {
+		func() {
+			defer func() {
+				if r := recover(); r != "callback" {
+					t.Fatal("Callback panic was not passed on, got", r)
+				}
+			}()
+			traverse()
+		}()
+
+		done := make(chan bool)
+		go func() {
+			tree.Add(NewNode(rndCoords(3)))
+			done <- true
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Adding a node after a panic in a callback deadlocked.")
+		}
+	}
+}
+
+// Builds a tree from quantized coordinates, so many nodes share a position, then checks that
+// FindAll returns every node at each position.
+func TestFindAll(t *testing.T) {
+	nl := make([]*Node, 1000)
+	for i := range nl {
+		nl[i] = NewNode([]float64{float64(rand.Intn(4)), float64(rand.Intn(4)), float64(rand.Intn(4))})
+	}
+	tree := BuildTree(nl[:500])
+	for _, n := range nl[500:] {
+		if err := tree.Add(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Validate(); err != nil {
+		t.Fatal("Tree is not valid: " + err.Error())
+	}
+
+	for _, n := range nl {
+		results, err := tree.FindAll(n.Coordinates)
+		if err != nil {
+			t.Fatal("Error while searching tree:", err)
+		}
+		if _, ok := find_nl(results, n); !ok {
+			t.Fatal(n.String(), "not found!")
+		}
+		count := 0
+		for _, o := range nl {
+			if equal_fl(o.Coordinates, n.Coordinates) {
+				count++
+			}
+		}
+		if len(results) != count {
+			t.Fatal("FindAll returned", len(results), "nodes at", n.String(), "expected", count)
+		}
+	}
+
+	if results, err := tree.FindAll([]float64{5, 5, 5}); results != nil || err != nil {
+		t.Fatal("Searching for missing coordinates should return (nil, nil), got", results, err)
+	}
+	if _, err := tree.FindAll([]float64{1, 1}); err == nil {
+		t.Fatal("Searching with the wrong number of dimensions should return an error.")
+	}
+}
+
 func TestFindRoot(t *testing.T) {
 	nl := genlist(6, 100000)
 	tree := BuildTree(nl)
@@ -95,6 +709,20 @@ func TestFindRoot(t *testing.T) {
 	}
 }
 
+// root() should handle parent chains far deeper than any balanced tree.
+func TestFindRootDeep(t *testing.T) {
+	top := NewNode(rndCoords(2))
+	n := top
+	for i := 0; i < 1000000; i++ {
+		child := NewNode(rndCoords(2))
+		child.parent = n
+		n = child
+	}
+	if root := n.root(); root != top {
+		t.Fatal("Found incorrect root " + root.String())
+	}
+}
+
 func TestAddNodes(t *testing.T) {
 	nl := genlist(6, 100000)
 	tree := BuildTree(nl)
@@ -186,18 +814,188 @@ func TestAddSubtree(t *testing.T) {
 	}
 }
 
-func TestRemoveNodes(t *testing.T) {
-	// order of magnitude smaller, because removals are an order of magnitude slower.
-	nl := genlist(6, 10000)
-	tree := BuildTree(nl)
-	// remove nodes from end of nodelist
-	for i := len(nl) - 500; i < len(nl); i++ {
-		size := tree.Size()
-		if err := tree.Remove(nl[i]); err != nil {
-			t.Fatal("Failed to remove node " + nl[i].String() + ", " + err.Error())
+// Adding a leaf detached from a bucket tree should add the nodes from its bucket as ordinary nodes,
+// whether or not one of them becomes the root, and leave none of them in a bucket.
+func TestAddBucketLeaf(t *testing.T) {
+	for _, dst := range []*Tree{new(Tree), BuildTree(genlist(3, 100))} {
+		src := BuildTreeBucket(genlist(3, 3), 4)
+		detached, err := src.Detach(src.Root)
+		if err != nil {
+			t.Fatal(err)
 		}
-		newsize := tree.Size()
-		diff := size - newsize
+		size := dst.Size()
+		if err := dst.Add(detached.Root); err != nil {
+			t.Fatal(err)
+		}
+		if dst.Size() != size+3 || len(dst.NodeList()) != size+3 {
+			t.Fatal("Tree has a Size of", dst.Size(), "and lists", len(dst.NodeList()), "nodes, expected", size+3)
+		}
+		if err := dst.Validate(); err != nil {
+			t.Fatal("Tree is not valid: " + err.Error())
+		}
+		if _, err := dst.GobEncode(); err != nil {
+			t.Fatal("Failed to encode tree: " + err.Error())
+		}
+	}
+}
+
+// Detaching a subtree should leave both trees valid, with every node in exactly one of them.
+func TestDetach(t *testing.T) {
+	for _, tree := range []*Tree{BuildTree(genlist(3, 5000)), BuildTreeBucket(genlist(3, 5000), 8)} {
+		nl := tree.NodeList()
+		n := tree.Root.rightChild.leftChild
+		detached, err := tree.Detach(n)
+		if err != nil {
+			t.Fatal("Error while detaching subtree:", err)
+		}
+		for _, tr := range []*Tree{tree, detached} {
+			if err := tr.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			if tr.Size() != len(tr.NodeList()) {
+				t.Fatal("Tree has size", tr.Size(), "but", len(tr.NodeList()), "nodes")
+			}
+		}
+		if detached.Root != n || tree.Size()+detached.Size() != len(nl) {
+			t.Fatal("Detached", detached.Size(), "nodes from", n.String(), "leaving", tree.Size(), "of", len(nl))
+		}
+		remaining, moved := tree.NodeList(), detached.NodeList()
+		for _, n := range nl {
+			_, inTree := find_nl(remaining, n)
+			_, inDetached := find_nl(moved, n)
+			if inTree == inDetached {
+				t.Fatal(n.String()+" in the tree:", inTree, "and in the detached tree:", inDetached)
+			}
+		}
+	}
+
+	tree := BuildTreeBucket(genlist(3, 100), 8)
+	leaves := tree.Leaves()
+	b := leaves[0]
+	if !b.inBucket() {
+		t.Fatal(b.String() + " should be in a bucket.")
+	}
+	if detached, err := tree.Detach(b); err != nil || detached.Size() != 1 || tree.Size() != 99 {
+		t.Fatal("Detaching a bucket node should move it alone to a new tree, got error", err)
+	}
+	if err := tree.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	root := tree.Root
+	if detached, err := tree.Detach(root); err != nil || detached.Size() != 99 || tree.Root != nil || tree.Size() != 0 {
+		t.Fatal("Detaching the root should empty the tree, got error", err)
+	}
+	if _, err := tree.Detach(root); err == nil {
+		t.Fatal("Detaching a node that isn't in the tree should return an error.")
+	}
+	if _, err := tree.Detach(nil); err == nil {
+		t.Fatal("Detaching a nil node should return an error.")
+	}
+}
+
+func TestAddUnique(t *testing.T) {
+	tree := new(Tree)
+	nl := genlist(3, 1000)
+	for _, n := range nl {
+		if added, existing, err := tree.AddUnique(n); !added || existing != nil || err != nil {
+			t.Fatal("Adding", n, "returned", added, existing, err)
+		}
+	}
+
+	for _, n := range nl[:100] {
+		dup := NewNode(append([]float64(nil), n.Coordinates...))
+		if added, existing, err := tree.AddUnique(dup); added || existing != n || err != nil {
+			t.Fatal("Adding a duplicate of", n, "returned", added, existing, err)
+		}
+	}
+	if size := tree.Size(); size != len(nl) {
+		t.Fatal("Tree has Size", size, "after adding duplicates, expected", len(nl))
+	}
+	if err := tree.Validate(); err != nil {
+		t.Fatal("Tree is not valid: " + err.Error())
+	}
+
+	if _, _, err := tree.AddUnique(NewNode(rndCoords(2))); err != ErrDimensionMismatch {
+		t.Fatal("Adding a node with the wrong dimensions should return ErrDimensionMismatch, got", err)
+	}
+}
+
+func TestAddAll(t *testing.T) {
+	nl := genlist(6, 5000)
+	tree := BuildTree(nl[:2500])
+	if err := tree.AddAll(nl[2500:]); err != nil {
+		t.Fatal("Failed to add nodes: " + err.Error())
+	}
+	if err := tree.Validate(); err != nil {
+		t.Fatal("Tree is not valid after adding nodes: " + err.Error())
+	}
+	for k, n := range nl {
+		if search, err := tree.Find(n.Coordinates); err != nil || search != n {
+			t.Fatal(strconv.FormatInt(int64(k), 10) + ": " + n.String() + " not found!")
+		}
+	}
+
+	if err := tree.AddAll(append(genlist(6, 10), NewNode(rndCoords(3)))); err != ErrDimensionMismatch {
+		t.Fatal("Adding a node with the wrong dimensions should return ErrDimensionMismatch, got", err)
+	}
+	if size := tree.Size(); size != len(nl) {
+		t.Fatal("Failed AddAll changed the tree size to " + strconv.FormatInt(int64(size), 10))
+	}
+}
+
+func BenchmarkAddAll(b *testing.B) {
+	b.StopTimer()
+	tree := BuildTree(genlist(6, b.N))
+	nl := genlist(6, b.N)
+	b.StartTimer()
+
+	tree.AddAll(nl)
+}
+
+func TestMerge(t *testing.T) {
+	nl1 := genlist(6, 7500)
+	nl2 := genlist(6, 2500)
+	tree1 := BuildTree(nl1)
+	tree2 := BuildTree(nl2)
+	if err := tree1.Merge(tree2); err != nil {
+		t.Fatal("Failed to merge trees: " + err.Error())
+	}
+	if tree2.Root != nil || tree2.Size() != 0 {
+		t.Fatal("Merged tree is not empty.")
+	}
+	if size := tree1.Size(); size != len(nl1)+len(nl2) {
+		t.Fatal("Merged tree has", size, "nodes, expected", len(nl1)+len(nl2))
+	}
+	if err := tree1.Validate(); err != nil {
+		t.Fatal("Tree is not valid after merge: " + err.Error())
+	}
+	for k, n := range append(nl1, nl2...) {
+		if search, err := tree1.Find(n.Coordinates); err != nil || search != n {
+			t.Fatal(strconv.FormatInt(int64(k), 10) + ": " + n.String() + " not found!")
+		}
+	}
+
+	if err := tree1.Merge(BuildTree(genlist(3, 10))); err != ErrDimensionMismatch {
+		t.Fatal("Merging trees with different dimensions should return ErrDimensionMismatch, got", err)
+	}
+	if err := tree1.Merge(tree1); err == nil {
+		t.Fatal("Merging a tree with itself should return an error.")
+	}
+}
+
+func TestRemoveNodes(t *testing.T) {
+	// order of magnitude smaller, because removals are an order of magnitude slower.
+	nl := genlist(6, 10000)
+	tree := BuildTree(nl)
+	// remove nodes from end of nodelist
+	for i := len(nl) - 500; i < len(nl); i++ {
+		size := tree.Size()
+		if err := tree.Remove(nl[i]); err != nil {
+			t.Fatal("Failed to remove node " + nl[i].String() + ", " + err.Error())
+		}
+		newsize := tree.Size()
+		diff := size - newsize
 		if diff != 1 {
 			t.Fatal("Removing one node shrunk the tree by " + strconv.FormatInt(int64(diff), 10) + " nodes.")
 		}
@@ -231,6 +1029,237 @@ func TestRemoveNodes(t *testing.T) {
 	}
 }
 
+// Concurrent removals, with searches running alongside them, should leave a valid tree holding
+// exactly the nodes that weren't removed. Run with -race to check the locking.
+func TestRemoveConcurrent(t *testing.T) {
+	nl := genlist(3, 5000)
+	tree := BuildTree(nl)
+	var wg sync.WaitGroup
+	for i := 0; i < 1000; i++ {
+		wg.Add(2)
+		go func(n *Node) {
+			defer wg.Done()
+			if err := tree.Remove(n); err != nil {
+				t.Error("Failed to remove node " + n.String() + ": " + err.Error())
+			}
+		}(nl[i])
+		go func() {
+			defer wg.Done()
+			if _, _, err := tree.NearestNeighbor(rndCoords(3)); err != nil {
+				t.Error("Error while searching tree:", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := tree.Validate(); err != nil {
+		t.Fatal("Tree is not valid after concurrent removals: " + err.Error())
+	}
+	if tree.Size() != 4000 || len(tree.NodeList()) != 4000 {
+		t.Fatal("Tree has size", tree.Size(), "and", len(tree.NodeList()), "nodes after removals, expected 4000")
+	}
+	for k, n := range nl {
+		if search, _ := tree.Find(n.Coordinates); (search == n) != (k >= 1000) {
+			t.Fatal(strconv.Itoa(k)+": "+n.String()+" found:", search != nil)
+		}
+	}
+}
+
+// Removing or moving nodes from another tree, or nodes already removed, should leave both trees
+// untouched.
+func TestNodeNotInTree(t *testing.T) {
+	nl, other := genlist(3, 1000), genlist(3, 1000)
+	tree, otherTree := BuildTree(nl), BuildTree(other)
+	if err := tree.Remove(nl[10]); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []*Node{other[0], otherTree.Root, nl[10]} {
+		if err := tree.Remove(n); err != ErrNodeNotInTree {
+			t.Fatal("Removing "+n.String()+" should return ErrNodeNotInTree, got", err)
+		}
+		if err := tree.Move(n, rndCoords(3)); err != ErrNodeNotInTree {
+			t.Fatal("Moving "+n.String()+" should return ErrNodeNotInTree, got", err)
+		}
+		if _, err := tree.Detach(n); err != ErrNodeNotInTree {
+			t.Fatal("Detaching "+n.String()+" should return ErrNodeNotInTree, got", err)
+		}
+	}
+	if tree.Size() != 999 || otherTree.Size() != 1000 {
+		t.Fatal("Trees have", tree.Size(), "and", otherTree.Size(), "nodes, expected 999 and 1000")
+	}
+	for _, check := range []*Tree{tree, otherTree} {
+		if err := check.Validate(); err != nil {
+			t.Fatal("Tree is not valid: " + err.Error())
+		}
+	}
+	for _, n := range other {
+		if found, _ := otherTree.Find(n.Coordinates); found != n {
+			t.Fatal(n.String() + " is missing from the other tree.")
+		}
+	}
+}
+
+func TestRemoveAt(t *testing.T) {
+	nl := genlist(6, 10000)
+	tree := BuildTree(nl)
+	for _, n := range nl[:5000] {
+		coords := append([]float64(nil), n.Coordinates...)
+		if removed, err := tree.RemoveAt(coords); err != nil {
+			t.Fatal("Failed to remove node " + n.String() + ", " + err.Error())
+		} else if !removed {
+			t.Fatal("Node " + n.String() + " was not removed.")
+		}
+		if removed, err := tree.RemoveAt(coords); err != nil || removed {
+			t.Fatal("Node " + n.String() + " was removed twice.")
+		}
+	}
+	if err := tree.Validate(); err != nil {
+		t.Fatal("Tree is not valid after removing nodes: " + err.Error())
+	}
+	if size := tree.Size(); size != 5000 {
+		t.Fatal("Tree has incorrect number of nodes after removal: " + strconv.FormatInt(int64(size), 10))
+	}
+	for k, n := range nl[5000:] {
+		if search, err := tree.Find(n.Coordinates); err != nil || search != n {
+			t.Fatal(strconv.FormatInt(int64(k), 10) + ": " + n.String() + " not found!")
+		}
+	}
+
+	if _, err := tree.RemoveAt(rndCoords(5)); err == nil {
+		t.Fatal("Removing with the wrong number of dimensions should return an error.")
+	}
+
+	noParents := BuildTreeNoParent(genlist(6, 100))
+	if removed, err := noParents.RemoveAt(noParents.Root.Coordinates); err != ErrNoParents || removed {
+		t.Fatal("RemoveAt on a tree without parents should return false and ErrNoParents, got", removed, err)
+	}
+}
+
+// The replacement for a removed node should be the minimum on its axis from the right subtree,
+// or the left subtree if there's no right subtree, and should take the removed node's place.
+func TestRemoveNode(t *testing.T) {
+	nl := genlist(3, 2000)
+	tree := BuildTree(nl)
+	for _, n := range nl[:1000] {
+		parent, axis := n.parent, n.axis
+		var expected *Node
+		if n.rightChild != nil {
+			expected = n.rightChild.findMin(axis)
+		} else if n.leftChild != nil {
+			expected = n.leftChild.findMin(axis)
+		}
+
+		replacement, err := tree.RemoveNode(n)
+		if err != nil {
+			t.Fatal("Failed to remove node " + n.String() + ", " + err.Error())
+		}
+		if replacement != expected {
+			t.Fatal("Replacement for", n, "should be", expected, "got", replacement)
+		}
+		if replacement != nil && (replacement.parent != parent || replacement.axis != axis) {
+			t.Fatal("Replacement " + replacement.String() + " did not take the place of " + n.String())
+		}
+		if replacement == nil && parent != nil && (parent.leftChild == n || parent.rightChild == n) {
+			t.Fatal("Leaf " + n.String() + " is still linked to its parent.")
+		}
+	}
+	if err := tree.Validate(); err != nil {
+		t.Fatal("Tree is not valid after removing nodes: " + err.Error())
+	}
+
+	if _, err := tree.RemoveNode(nl[0]); err == nil {
+		t.Fatal("Removing a node twice should return an error.")
+	}
+
+	// a leaf holding a bucket is replaced by the first node in it, and bucket nodes by nothing
+	bucketTree := BuildTreeBucket(genlist(3, 3), 4)
+	leaf, first, second := bucketTree.Root, bucketTree.Root.bucket[0], bucketTree.Root.bucket[1]
+	if replacement, err := bucketTree.RemoveNode(second); err != nil || replacement != nil {
+		t.Fatal("Removing a node from a bucket returned", replacement, err)
+	}
+	if replacement, err := bucketTree.RemoveNode(leaf); err != nil || replacement != first {
+		t.Fatal("Removing a leaf with a bucket should return the first node in its bucket, got", replacement, err)
+	}
+}
+
+func TestRemoveMatching(t *testing.T) {
+	nl := genlist(4, 10000)
+	tree := BuildTree(nl)
+	stale := func(n *Node) bool {
+		return n.Coordinates[0] < 0.3
+	}
+	expected := 0
+	for _, n := range nl {
+		if stale(n) {
+			expected++
+		}
+	}
+
+	if removed := tree.RemoveMatching(stale); removed != expected {
+		t.Fatal("RemoveMatching removed", removed, "nodes, expected", expected)
+	}
+	if err := tree.Validate(); err != nil {
+		t.Fatal("Tree is not valid after removing nodes: " + err.Error())
+	}
+	if size := tree.Size(); size != len(nl)-expected {
+		t.Fatal("Tree has", size, "nodes after removal, expected", len(nl)-expected)
+	}
+	for _, n := range nl {
+		search, err := tree.Find(n.Coordinates)
+		if err != nil {
+			t.Fatal("Error while searching tree:", err)
+		}
+		if stale(n) && (search != nil || n.parent != nil || n.leftChild != nil || n.rightChild != nil) {
+			t.Fatal(n.String(), "was not removed.")
+		} else if !stale(n) && search != n {
+			t.Fatal(n.String(), "not found!")
+		}
+	}
+
+	if removed := tree.RemoveMatching(stale); removed != 0 {
+		t.Fatal("RemoveMatching removed", removed, "nodes a second time.")
+	}
+}
+
+func TestMove(t *testing.T) {
+	nl := make([]*Node, 10000)
+	for i := range nl {
+		nl[i] = NewNodeWithValue(rndCoords(6), i)
+	}
+	tree := BuildTree(nl)
+	for i, n := range nl[:1000] {
+		coords := make([]float64, 6)
+		for j := range coords {
+			// move half the nodes a tiny distance, so some can be updated in place
+			if i%2 == 0 {
+				coords[j] = n.Coordinates[j] + (rand.Float64()-0.5)/1000
+			} else {
+				coords[j] = rand.Float64()
+			}
+		}
+		if err := tree.Move(n, coords); err != nil {
+			t.Fatal("Failed to move node " + n.String() + ", " + err.Error())
+		}
+	}
+	if err := tree.Validate(); err != nil {
+		t.Fatal("Tree is not valid after moving nodes: " + err.Error())
+	}
+	if size := tree.Size(); size != len(nl) {
+		t.Fatal("Tree has incorrect number of nodes after moving: " + strconv.FormatInt(int64(size), 10))
+	}
+	for k, n := range nl {
+		if search, err := tree.Find(n.Coordinates); err != nil || search != n {
+			t.Fatal(strconv.FormatInt(int64(k), 10) + ": " + n.String() + " not found!")
+		} else if search.Value != k {
+			t.Fatal(strconv.FormatInt(int64(k), 10)+": "+n.String()+" has the wrong value:", search.Value)
+		}
+	}
+
+	if err := tree.Move(nl[0], rndCoords(5)); err == nil {
+		t.Fatal("Moving to coordinates with the wrong number of dimensions should return an error.")
+	}
+}
+
 func BenchmarkRemoveNodes(b *testing.B) {
 	b.StopTimer()
 	nl := genlist(6, b.N*2)
@@ -244,41 +1273,463 @@ func BenchmarkRemoveNodes(b *testing.B) {
 			donechan <- true
 		}()
 	}
-	// wait for goroutines to finish
-	for i := 0; i < b.N; i++ {
-		<-donechan
+	// wait for goroutines to finish
+	for i := 0; i < b.N; i++ {
+		<-donechan
+	}
+}
+
+func TestClone(t *testing.T) {
+	nl := genlist(6, 10000)
+	for i, n := range nl {
+		n.Index = i
+	}
+	tree := BuildTree(nl)
+	tree.AutoBalanceFactor = 2
+	tree.TrackAccess = true
+	tree.Alpha = 0.7
+	tree.LinearScanDimensions = -1
+	clone := tree.Clone()
+	checkCopy(t, clone, nl)
+	other := tree.Clone()
+	detached, _ := other.Detach(other.Root.leftChild)
+	for _, c := range []*Tree{clone, detached} {
+		if c.AutoBalanceFactor != 2 {
+			t.Fatal("Copy of the tree has AutoBalanceFactor", c.AutoBalanceFactor, "expected 2")
+		}
+		if !c.TrackAccess {
+			t.Fatal("Copy of the tree doesn't track access.")
+		}
+		if c.Alpha != 0.7 {
+			t.Fatal("Copy of the tree has Alpha", c.Alpha, "expected 0.7")
+		}
+		if c.LinearScanDimensions != -1 {
+			t.Fatal("Copy of the tree has LinearScanDimensions", c.LinearScanDimensions, "expected -1")
+		}
+	}
+
+	// changes to the clone shouldn't affect the original, or the other way around
+	for _, n := range nl[:100] {
+		if removed, err := clone.RemoveAt(n.Coordinates); err != nil || !removed {
+			t.Fatal("Failed to remove " + n.String() + " from clone.")
+		}
+	}
+	old := tree.Root.Coordinates[0]
+	tree.Root.Coordinates[0] = -1
+	if clone.Root.Coordinates[0] == -1 {
+		t.Fatal("Clone shares coordinates with the original tree.")
+	}
+	tree.Root.Coordinates[0] = old
+	checkCopy(t, tree, nl)
+	if size := clone.Size(); size != len(nl)-100 {
+		t.Fatal("Clone has " + strconv.FormatInt(int64(size), 10) + " nodes after removal.")
+	}
+}
+
+func TestNodeListInto(t *testing.T) {
+	nl := genlist(6, 1000)
+	tree := BuildTree(nl)
+	if list := tree.NodeList(); len(list) != len(nl) || cap(list) != len(nl) {
+		t.Fatal("NodeList returned", len(list), "nodes with capacity", cap(list), "expected", len(nl))
+	}
+
+	buf := make([]*Node, 5, len(nl))
+	list := tree.NodeListInto(buf)
+	if len(list) != len(nl) {
+		t.Fatal("NodeListInto returned", len(list), "nodes, expected", len(nl))
+	}
+	if &list[0] != &buf[0] {
+		t.Fatal("NodeListInto did not reuse a buffer with enough capacity.")
+	}
+	for _, n := range nl {
+		if _, ok := find_nl(list, n); !ok {
+			t.Fatal(n.String(), "missing from node list.")
+		}
+	}
+
+	if list := tree.NodeListInto(make([]*Node, 0, 10)); len(list) != len(nl) {
+		t.Fatal("NodeListInto returned", len(list), "nodes from a small buffer, expected", len(nl))
+	}
+}
+
+// Coordinates should list a copy of every node's coordinates in NodeList order.
+func TestCoordinates(t *testing.T) {
+	nl := genlist(3, 1000)
+	tree := BuildTree(nl)
+	coords := tree.Coordinates()
+	list := tree.NodeList()
+	if len(coords) != len(list) {
+		t.Fatal("Coordinates returned", len(coords), "rows, expected", len(list))
+	}
+	for i, n := range list {
+		if !equal_fl(coords[i], n.Coordinates) {
+			t.Fatal("Row", i, "is", String(coords[i]), "expected", n.String())
+		}
+	}
+
+	coords[0][0] = -1
+	coords[0] = append(coords[0], 5)
+	if list[0].Coordinates[0] == -1 || !equal_fl(coords[1], list[1].Coordinates) {
+		t.Fatal("Changing the returned coordinates changed the tree or another row.")
+	}
+	if coords := new(Tree).Coordinates(); coords != nil {
+		t.Fatal("An empty tree should have nil coordinates, got", coords)
+	}
+}
+
+func TestNodeListOrdered(t *testing.T) {
+	// in a one dimensional tree, an in-order listing is sorted
+	nl := make([]*Node, 100)
+	for i, v := range rand.Perm(len(nl)) {
+		nl[i] = NewNode([]float64{float64(v)})
+	}
+	tree := BuildTree(nl)
+	for i, n := range tree.NodeListOrdered(InOrder) {
+		if n.Coordinates[0] != float64(i) {
+			t.Fatal("InOrder listed", n, "at index", i)
+		}
+	}
+
+	nl = genlist(3, 1000)
+	tree = BuildTree(nl)
+	pre := tree.NodeListOrdered(PreOrder)
+	if len(pre) != len(nl) || pre[0] != tree.Root {
+		t.Fatal("PreOrder listed", len(pre), "nodes, starting with", pre[0], "expected", len(nl), "starting with the root")
+	}
+	index := make(map[*Node]int)
+	for i, n := range pre {
+		index[n] = i
+	}
+	for _, n := range pre[1:] {
+		if index[n.parent] >= index[n] {
+			t.Fatal("PreOrder listed", n, "before its parent")
+		}
+	}
+
+	post, list := tree.NodeListOrdered(PostOrder), tree.NodeList()
+	for i := range list {
+		if post[i] != list[i] {
+			t.Fatal("PostOrder listed", post[i], "at index", i, "where NodeList has", list[i])
+		}
+	}
+}
+
+func TestLeaves(t *testing.T) {
+	nl := genlist(4, 1000)
+	tree := BuildTree(nl)
+	leaves := tree.Leaves()
+	count := 0
+	for _, n := range nl {
+		isLeaf := n.leftChild == nil && n.rightChild == nil
+		if isLeaf {
+			count++
+		}
+		if _, ok := find_nl(leaves, n); ok != isLeaf {
+			t.Fatal(n.String(), "is a leaf:", isLeaf, "but in Leaves:", ok)
+		}
+	}
+	if len(leaves) != count {
+		t.Fatal("Leaves returned", len(leaves), "nodes, expected", count)
+	}
+
+	if leaves := new(Tree).Leaves(); len(leaves) != 0 {
+		t.Fatal("An empty tree should have no leaves, found", len(leaves))
+	}
+}
+
+// Every node should be sampled about equally often, and never twice in one sample.
+func TestSample(t *testing.T) {
+	nl := genlist(3, 20)
+	tree := BuildTreeBucket(nl, 3)
+	counts := make(map[*Node]int)
+	for i := 0; i < 20000; i++ {
+		sample := tree.Sample(5)
+		if len(sample) != 5 {
+			t.Fatal("Sample returned", len(sample), "nodes, expected 5")
+		}
+		seen := make(map[*Node]bool)
+		for _, n := range sample {
+			if seen[n] {
+				t.Fatal(n.String() + " sampled twice.")
+			}
+			seen[n] = true
+			counts[n]++
+		}
+	}
+	// each node is expected in a quarter of the samples, 5000 of them, with a standard deviation of about 61
+	for _, n := range nl {
+		if counts[n] < 4500 || counts[n] > 5500 {
+			t.Fatal(n.String()+" sampled", counts[n], "times, expected about 5000")
+		}
+	}
+
+	if all := tree.Sample(100); len(all) != 20 {
+		t.Fatal("Sampling more nodes than the tree has should return all 20, returned", len(all))
+	}
+	if none := tree.Sample(0); none != nil {
+		t.Fatal("Sampling 0 nodes should return nil, returned", len(none))
+	}
+}
+
+func TestClear(t *testing.T) {
+	tree := BuildTree(genlist(6, 1000))
+	tree.Clear()
+	if size := tree.Size(); size != 0 {
+		t.Fatal("Cleared tree has " + strconv.FormatInt(int64(size), 10) + " nodes.")
+	}
+	if nl := tree.NodeList(); nl == nil || len(nl) != 0 {
+		t.Fatal("Cleared tree should have an empty node list.")
+	}
+	if search, err := tree.Find(rndCoords(6)); search != nil || err != nil {
+		t.Fatal("Searching a cleared tree should return (nil, nil).")
+	}
+
+	// the tree should be usable again after clearing
+	n := NewNode(rndCoords(6))
+	if err := tree.Add(n); err != nil {
+		t.Fatal("Failed to add node " + n.String() + ": " + err.Error())
+	}
+	if search, err := tree.Find(n.Coordinates); err != nil || search != n {
+		t.Fatal(n.String() + " not found after clearing tree.")
+	}
+}
+
+func TestBalance(t *testing.T) {
+	// first, generate an unbalanced tree on purpose
+	tree := new(Tree)
+	tree.Add(NewNode([]float64{0.0, 0.0, 0.0, 0.0, 0.0, 0.0}))
+	for i := 0; i < 100000; i++ {
+		// Because the tree root is (0.0...), and math.rand generates numbers in [0.0,1.0), these nodes
+		// will all fall to the right of the root.
+		n := NewNode(rndCoords(6))
+		if err := tree.Add(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tree.Root.leftChild.size() > 0 {
+		t.Fatal("Left branch should always be empty after unbalanced generation.")
+	}
+	start_depth := tree.Depth()
+	tree.Balance()
+	end_depth := tree.Depth()
+	depth_diff := start_depth - end_depth
+
+	// check for balancing errors
+	if depth_diff <= 0 {
+		t.Fatal("New tree has a depth >= old tree.")
+	}
+	left_size := tree.Root.leftChild.size()
+	right_size := tree.Root.rightChild.size()
+	size_diff := left_size - right_size
+	if size_diff > 10 || size_diff < -10 {
+		t.Error("Left and right branches have a node difference > 10 (" + strconv.FormatInt(int64(size_diff), 10))
+	}
+}
+
+// Size is tracked as the tree changes, so it should always match the number of nodes in the tree.
+func TestSize(t *testing.T) {
+	tree := BuildTree(genlist(3, 1000))
+	tree.AutoBalanceFactor = 2
+	check := func(op string) {
+		if size, count := tree.Size(), tree.Root.size(); size != count {
+			t.Fatal("Size is", size, "after", op, "but tree has", count, "nodes")
+		}
+	}
+	check("BuildTree")
+
+	for i := 0; i < 1000; i++ {
+		tree.Add(NewNode(rndCoords(3)))
+	}
+	check("Add")
+	if err := tree.AddAll(genlist(3, 500)); err != nil {
+		t.Fatal(err)
+	}
+	check("AddAll")
+	for _, n := range tree.Root.nodeList()[:700] {
+		if err := tree.Remove(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check("Remove")
+	for _, n := range tree.Root.nodeList()[:100] {
+		if err := tree.Move(n, rndCoords(3)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check("Move")
+	if clone := tree.Clone(); clone.Size() != tree.Size() {
+		t.Fatal("Clone has", clone.Size(), "nodes, tree has", tree.Size())
+	}
+	tree.Clear()
+	check("Clear")
+}
+
+// Every node's cached height should match the depth of its subtree as the tree changes.
+func TestDepth(t *testing.T) {
+	tree := BuildTree(genlist(3, 1000))
+	check := func(op string) {
+		if depth, count := tree.Depth(), tree.Root.depth(); depth != count {
+			t.Fatal("Depth is", depth, "after", op, "but tree is", count, "deep")
+		}
+		tree.Traverse(func(n *Node) {
+			if n.height != n.depth() {
+				t.Fatal(n.String(), "has height", n.height, "after", op, "but its subtree is", n.depth(), "deep")
+			}
+		})
+	}
+	check("BuildTree")
+
+	for i := 0; i < 1000; i++ {
+		tree.Add(NewNode(rndCoords(3)))
+	}
+	check("Add")
+	for _, n := range tree.Root.nodeList()[:1500] {
+		if err := tree.Remove(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check("Remove")
+	for _, n := range tree.Root.nodeList()[:100] {
+		if err := tree.Move(n, rndCoords(3)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check("Move")
+	tree.RebalanceSubtree(tree.Root.leftChild)
+	check("RebalanceSubtree")
+	tree = tree.Clone()
+	check("Clone")
+}
+
+func TestStats(t *testing.T) {
+	tree := BuildTree(genlist(6, 10000))
+	stats := tree.Stats()
+	if stats.Size != tree.Size() || stats.MaxDepth != tree.Depth() {
+		t.Fatal("Stats", stats, "don't match Size", tree.Size(), "and Depth", tree.Depth())
+	}
+	if stats.MinLeafDepth > stats.MaxDepth || stats.AvgLeafDepth < float64(stats.MinLeafDepth) || stats.AvgLeafDepth > float64(stats.MaxDepth) {
+		t.Fatal("Leaf depths in", stats, "are inconsistent.")
+	}
+	if stats.BalanceFactor > 1.1 {
+		t.Fatal("Balanced tree has balance factor", stats.BalanceFactor)
+	}
+
+	// a tree built by adding nodes in increasing order is a single chain
+	tree = new(Tree)
+	for i := 0; i < 100; i++ {
+		tree.Add(NewNode([]float64{float64(i), float64(i)}))
+	}
+	stats = tree.Stats()
+	if stats.MaxDepth != 100 || stats.MinLeafDepth != 100 || stats.AvgLeafDepth != 100 {
+		t.Fatal("Stats for a chain of 100 nodes are", stats)
+	}
+	if stats.BalanceFactor < 10 {
+		t.Fatal("Chain of 100 nodes has balance factor", stats.BalanceFactor)
+	}
+
+	if stats := new(Tree).Stats(); stats != (TreeStats{}) {
+		t.Fatal("Stats for an empty tree are", stats)
+	}
+}
+
+func TestAutoBalance(t *testing.T) {
+	// nodes added in increasing order would form a single chain without rebalancing
+	tree := new(Tree)
+	tree.AutoBalanceFactor = 2
+	nl := make([]*Node, 1000)
+	for i := range nl {
+		nl[i] = NewNode([]float64{float64(i), float64(i)})
+		if err := tree.Add(nl[i]); err != nil {
+			t.Fatal(err)
+		}
+		if depth, limit := tree.Depth(), 2*math.Log2(float64(i+1)); i > 0 && float64(depth) > limit {
+			t.Fatal("Tree of", i+1, "nodes has depth", depth, "over the auto balance limit", limit)
+		}
+	}
+	if err := tree.Validate(); err != nil {
+		t.Fatal("Tree is not valid after auto balancing: " + err.Error())
+	}
+	for k, n := range nl {
+		if search, err := tree.Find(n.Coordinates); err != nil || search != n {
+			t.Fatal(strconv.FormatInt(int64(k), 10) + ": " + n.String() + " not found!")
+		}
+	}
+}
+
+// Nodes added in increasing order should be kept within the scapegoat tree depth limit by rebuilding
+// subtrees, without rebuilding from the root on every insert.
+func TestAlpha(t *testing.T) {
+	tree := new(Tree)
+	tree.Alpha = 0.7
+	nl := make([]*Node, 2000)
+	rebuilds := 0
+	for i := range nl {
+		nl[i] = NewNode([]float64{float64(i), float64(-i)})
+		root := tree.Root
+		if err := tree.Add(nl[i]); err != nil {
+			t.Fatal(err)
+		}
+		if root != nil && tree.Root != root {
+			rebuilds++
+		}
+		if depth, limit := tree.Depth(), math.Log(float64(i+1))/math.Log(1/0.7)+2; float64(depth) > limit {
+			t.Fatal("Tree of", i+1, "nodes has depth", depth, "over the scapegoat limit", limit)
+		}
+	}
+	if rebuilds > 100 {
+		t.Fatal("The whole tree was rebuilt", rebuilds, "times while adding", len(nl), "nodes.")
+	}
+	if err := tree.Validate(); err != nil {
+		t.Fatal("Tree is not valid after scapegoat rebuilds: " + err.Error())
+	}
+	for k, n := range nl {
+		if search, err := tree.Find(n.Coordinates); err != nil || search != n {
+			t.Fatal(strconv.FormatInt(int64(k), 10) + ": " + n.String() + " not found!")
+		}
+	}
+	if size := tree.Root.size(); size != tree.Size() {
+		t.Fatal("Tree holds", size, "nodes, but has a Size of", tree.Size())
 	}
 }
 
-func TestBalance(t *testing.T) {
-	// first, generate an unbalanced tree on purpose
+func TestRebalanceSubtree(t *testing.T) {
+	// adding nodes in increasing order builds a chain down the right of the root
 	tree := new(Tree)
-	tree.Add(NewNode([]float64{0.0, 0.0, 0.0, 0.0, 0.0, 0.0}))
-	for i := 0; i < 100000; i++ {
-		// Because the tree root is (0.0...), and math.rand generates numbers in [0.0,1.0), these nodes
-		// will all fall to the right of the root.
-		n := NewNode(rndCoords(6))
-		if err := tree.Add(n); err != nil {
-			t.Fatal(err)
-		}
+	nl := make([]*Node, 200)
+	for i := range nl {
+		nl[i] = NewNode([]float64{float64(i), float64(i)})
+		tree.Add(nl[i])
 	}
-	if tree.Root.leftChild.size() > 0 {
-		t.Fatal("Left branch should always be empty after unbalanced generation.")
+	root, branch := tree.Root, tree.Root.rightChild
+	tree.RebalanceSubtree(branch)
+
+	if tree.Root != root {
+		t.Fatal("Rebalancing a subtree changed the tree root.")
+	}
+	if root.rightChild.parent != root {
+		t.Fatal("Rebalanced subtree is not linked to its parent.")
+	}
+	if depth := tree.Depth(); depth > 10 {
+		t.Fatal("Tree has depth", depth, "after rebalancing the root's subtree.")
+	}
+	if err := tree.Validate(); err != nil {
+		t.Fatal("Tree is not valid after rebalancing subtree: " + err.Error())
+	}
+	for k, n := range nl {
+		if search, err := tree.Find(n.Coordinates); err != nil || search != n {
+			t.Fatal(strconv.FormatInt(int64(k), 10) + ": " + n.String() + " not found!")
+		}
 	}
-	start_depth := tree.Depth()
-	tree.Balance()
-	end_depth := tree.Depth()
-	depth_diff := start_depth - end_depth
 
-	// check for balancing errors
-	if depth_diff <= 0 {
-		t.Fatal("New tree has a depth >= old tree.")
+	// a branch of another tree should be left alone
+	other := new(Tree)
+	for i := 0; i < 50; i++ {
+		other.Add(NewNode([]float64{float64(i), float64(i)}))
 	}
-	left_size := tree.Root.leftChild.size()
-	right_size := tree.Root.rightChild.size()
-	size_diff := left_size - right_size
-	if size_diff > 10 || size_diff < -10 {
-		t.Error("Left and right branches have a node difference > 10 (" + strconv.FormatInt(int64(size_diff), 10))
+	otherBranch := other.Root.rightChild
+	tree.RebalanceSubtree(otherBranch)
+	if other.Root.rightChild != otherBranch || other.Depth() != 50 {
+		t.Fatal("Rebalancing a node from another tree changed that tree.")
 	}
 }
 
@@ -300,11 +1751,7 @@ func TestFindRange(t *testing.T) {
 		go func() {
 			ranges := make(map[int]Range)
 			for axis := rand.Intn(6); len(ranges) < rand.Intn(6)+1; axis = rand.Intn(6) {
-				r := Range{rand.Float64(), rand.Float64()}
-				if r.Min > r.Max {
-					r.Min, r.Max = r.Max, r.Min
-				}
-				ranges[axis] = r
+				ranges[axis], _ = NewRange(rand.Float64(), rand.Float64())
 			}
 			results1, err := tree.FindRange(ranges)
 			if err != nil {
@@ -338,6 +1785,203 @@ func TestFindRange(t *testing.T) {
 	}
 }
 
+func TestFindRangeContext(t *testing.T) {
+	nl := genlist(6, 20000)
+	tree := BuildTree(nl)
+	everything := map[int]Range{0: Range{math.Inf(-1), math.Inf(1)}}
+
+	if results, err := tree.FindRangeContext(context.Background(), everything); err != nil {
+		t.Fatal(err)
+	} else if len(results) != len(nl) {
+		t.Fatal("FindRangeContext returned", len(results), "nodes, expected", len(nl))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if results, err := tree.FindRangeContext(ctx, everything); err != context.Canceled {
+		t.Fatal("Searching with a cancelled context should return context.Canceled, got", err)
+	} else if results != nil {
+		t.Fatal("Searching with a cancelled context returned", len(results), "partial results.")
+	}
+}
+
+// Errors should report dimensions and axes as numbers.
+// Half-open ranges tiling the unit square should match every node exactly once, even when
+// nodes lie on the shared edges.
+func TestFindRangeBounds(t *testing.T) {
+	nl := make([]*Node, 5000)
+	for i := range nl {
+		nl[i] = NewNode([]float64{float64(rand.Intn(10)) / 10, float64(rand.Intn(10)) / 10})
+	}
+	tree := BuildTree(nl)
+
+	seen := make(map[*Node]int)
+	for x := 0.0; x < 1; x += 0.25 {
+		for y := 0.0; y < 1; y += 0.5 {
+			results, err := tree.FindRangeBounds(map[int]Range{0: Range{x, x + 0.25}, 1: Range{y, y + 0.5}}, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, n := range results {
+				if n.Coordinates[0] >= x+0.25 || n.Coordinates[1] >= y+0.5 {
+					t.Fatal("Half-open range search returned", n, "on its upper bound.")
+				}
+				seen[n]++
+			}
+		}
+	}
+	for _, n := range nl {
+		if seen[n] != 1 {
+			t.Fatal(n, "was matched by", seen[n], "ranges, expected 1")
+		}
+	}
+
+	ranges := map[int]Range{0: Range{0.2, 0.5}}
+	inclusive, err := tree.FindRangeBounds(ranges, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, _ := tree.FindRange(ranges); len(inclusive) != len(expected) {
+		t.Fatal("Inclusive FindRangeBounds returned", len(inclusive), "nodes, FindRange returned", len(expected))
+	}
+}
+
+// Ranges with infinite bounds should only be restricted by their finite bound, and ranges with
+// both bounds infinite should match every node, including nodes at infinite coordinates.
+func TestFindRangeInfinite(t *testing.T) {
+	inf, negInf := math.Inf(1), math.Inf(-1)
+	nl := []*Node{NewNode([]float64{inf, 0}), NewNode([]float64{negInf, 1})}
+	for i := 0; i < 1000; i++ {
+		nl = append(nl, NewNode([]float64{float64(rand.Intn(10)), float64(rand.Intn(10))}))
+	}
+	tree := BuildTree(nl)
+
+	count := func(match func(x float64) bool) int {
+		matches := 0
+		for _, n := range nl {
+			if match(n.Coordinates[0]) {
+				matches++
+			}
+		}
+		return matches
+	}
+	tests := []struct {
+		r         Range
+		inclusive bool
+		expected  int
+	}{
+		{Range{negInf, 5}, true, count(func(x float64) bool { return x <= 5 })},
+		{Range{negInf, 5}, false, count(func(x float64) bool { return x < 5 })},
+		{Range{5, inf}, true, count(func(x float64) bool { return x >= 5 })},
+		{Range{5, inf}, false, count(func(x float64) bool { return x >= 5 })},
+		{Range{negInf, inf}, false, len(nl)},
+		{Range{inf, negInf}, true, len(nl)},
+		{Range{inf, inf}, true, 1},
+		{Range{negInf, negInf}, true, 1},
+	}
+	for _, test := range tests {
+		ranges := map[int]Range{0: test.r}
+		results, err := tree.FindRangeBounds(ranges, test.inclusive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != test.expected {
+			t.Fatal("Range", test.r, "inclusive", test.inclusive, "matched", len(results), "nodes, expected", test.expected)
+		}
+		if test.inclusive {
+			if count, _ := tree.CountRange(ranges); count != test.expected {
+				t.Fatal("Range", test.r, "counted", count, "nodes, expected", test.expected)
+			}
+		}
+	}
+}
+
+func TestFindRangeStream(t *testing.T) {
+	nl := genlist(6, 20000)
+	tree := BuildTree(nl)
+	ranges := map[int]Range{0: Range{0.25, 0.75}, 3: Range{0.1, 0.5}}
+
+	expected, err := tree.FindRange(ranges)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes, errs := tree.FindRangeStream(context.Background(), ranges)
+	count := 0
+	for n := range nodes {
+		if _, ok := find_nl(expected, n); !ok {
+			t.Fatal("Node from stream not found in FindRange results:", n)
+		}
+		count++
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if count != len(expected) {
+		t.Fatal("FindRangeStream sent", count, "nodes, FindRange returned", len(expected))
+	}
+
+	nodes, errs = tree.FindRangeStream(context.Background(), map[int]Range{6: Range{0, 1}})
+	for n := range nodes {
+		t.Fatal("Searching with an invalid axis sent", n)
+	}
+	if err := <-errs; err == nil {
+		t.Fatal("Searching with an invalid axis should send an error.")
+	}
+	// the stream must have released its lock
+	tree.Add(NewNode(rndCoords(6)))
+
+	// a consumer stopping early cancels the search, which should release the lock
+	ctx, cancel := context.WithCancel(context.Background())
+	nodes, errs = tree.FindRangeStream(ctx, ranges)
+	<-nodes
+	cancel()
+	if err := <-errs; err != context.Canceled {
+		t.Fatal("Cancelling a stream should send context.Canceled, got", err)
+	}
+	if _, ok := <-nodes; ok {
+		t.Fatal("Cancelling a stream should close the node channel.")
+	}
+	tree.Add(NewNode(rndCoords(6)))
+}
+
+func TestErrorMessages(t *testing.T) {
+	tree := BuildTree(genlist(6, 100))
+	expected := "Search coordinates have 5 dimensions, tree has 6 dimensions."
+	if _, err := tree.Find(rndCoords(5)); err == nil || err.Error() != expected {
+		t.Fatal("Find returned error", err, "expected", expected)
+	}
+	expected = "Range on axis 17 exceeds tree dimensions."
+	if _, err := tree.FindRange(map[int]Range{17: Range{0, 1}}); err == nil || err.Error() != expected {
+		t.Fatal("FindRange returned error", err, "expected", expected)
+	}
+	snl := sortableNodeList{0, tree.NodeList()}
+	if _, err := snl.findrange(map[int]Range{17: Range{0, 1}}); err == nil || err.Error() != expected {
+		t.Fatal("findrange returned error", err, "expected", expected)
+	}
+}
+
+func TestNewRange(t *testing.T) {
+	if r, err := NewRange(1, 2); err != nil || r != (Range{1, 2}) {
+		t.Fatal("NewRange(1, 2) returned", r, err)
+	}
+	if r, err := NewRange(2, 1); err != nil || r != (Range{1, 2}) {
+		t.Fatal("NewRange(2, 1) should swap its bounds, returned", r, err)
+	}
+	if _, err := NewRange(math.NaN(), 1); err == nil {
+		t.Fatal("NewRange should reject a NaN bound.")
+	}
+
+	tree := BuildTree(genlist(3, 100))
+	nan := map[int]Range{1: Range{0, math.NaN()}}
+	expected := "Range on axis 1 has a NaN bound."
+	if _, err := tree.FindRange(nan); err == nil || err.Error() != expected {
+		t.Fatal("FindRange returned error", err, "expected", expected)
+	}
+	if _, err := tree.CountRange(nan); err == nil || err.Error() != expected {
+		t.Fatal("CountRange returned error", err, "expected", expected)
+	}
+}
+
 func BenchmarkFindRange(b *testing.B) {
 	b.StopTimer()
 	nl := genlist(6, b.N*2)
@@ -347,14 +1991,300 @@ func BenchmarkFindRange(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		ranges := make(map[int]Range)
 		for axis := rand.Intn(6); len(ranges) < 2; axis = rand.Intn(6) {
-			r := Range{rand.Float64(), rand.Float64()}
-			if r.Min > r.Max {
-				r.Min, r.Max = r.Max, r.Min
-			}
-			ranges[axis] = r
+			ranges[axis], _ = NewRange(rand.Float64(), rand.Float64())
 		}
 		if _, err := tree.FindRange(ranges); err != nil {
 			b.Fatal(err)
 		}
 	}
 }
+
+// FindRangeInto should return the same nodes as FindRange, reusing the buffer passed to it.
+func TestFindRangeInto(t *testing.T) {
+	tree := BuildTreeBucket(genlist(6, 20000), 4)
+	buf := make([]*Node, 0, 20000)
+	for i := 0; i < 100; i++ {
+		ranges := make(map[int]Range)
+		for axis := rand.Intn(6); len(ranges) < rand.Intn(6)+1; axis = rand.Intn(6) {
+			ranges[axis], _ = NewRange(rand.Float64(), rand.Float64())
+		}
+		expected, _ := tree.FindRange(ranges)
+		results, err := tree.FindRangeInto(ranges, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != len(expected) {
+			t.Fatal("FindRangeInto returned", len(results), "nodes, FindRange returned", len(expected))
+		}
+		for j, n := range results {
+			if n != expected[j] {
+				t.Fatal("Node", j, "is", n.String(), "FindRange returned", expected[j].String())
+			}
+		}
+		if len(results) > 0 && &results[:1][0] != &buf[:1][0] {
+			t.Fatal("FindRangeInto allocated a new slice despite buf having enough capacity.")
+		}
+		buf = results
+	}
+
+	if results, err := tree.FindRangeInto(map[int]Range{6: Range{0, 1}}, buf); err == nil || len(results) != 0 {
+		t.Fatal("Searching a range outside of the tree's dimensions should return an empty list and an error.")
+	}
+}
+
+func BenchmarkFindRangeInto(b *testing.B) {
+	b.StopTimer()
+	nl := genlist(6, b.N*2)
+	tree := BuildTree(nl)
+	var buf []*Node
+	b.StartTimer()
+
+	for i := 0; i < b.N; i++ {
+		ranges := make(map[int]Range)
+		for axis := rand.Intn(6); len(ranges) < 2; axis = rand.Intn(6) {
+			ranges[axis], _ = NewRange(rand.Float64(), rand.Float64())
+		}
+		var err error
+		if buf, err = tree.FindRangeInto(ranges, buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Nodes sharing leading coordinates should all be found whatever their other coordinates, including
+// from a bucket tree where some of them are held in buckets.
+func TestFindByPrefix(t *testing.T) {
+	nl := genlist(3, 2000)
+	for i, n := range nl {
+		n.Coordinates[0] = float64(i % 10)
+		n.Coordinates[1] = float64(i % 7)
+	}
+	for _, leafSize := range []int{1, 6} {
+		tree := BuildTreeBucket(nl, leafSize)
+		for _, prefix := range [][]float64{{3}, {3, 4}, {3, 4, nl[53].Coordinates[2]}, {}} {
+			results, err := tree.FindByPrefix(prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := 0
+			for _, n := range nl {
+				if equal_fl(n.Coordinates[:len(prefix)], prefix) {
+					expected++
+				}
+			}
+			if len(results) != expected {
+				t.Fatal("FindByPrefix", prefix, "returned", len(results), "nodes, expected", expected)
+			}
+			for _, n := range results {
+				if !equal_fl(n.Coordinates[:len(prefix)], prefix) {
+					t.Fatal(n.String()+" doesn't start with", prefix)
+				}
+			}
+		}
+		if results, err := tree.FindByPrefix([]float64{10}); results != nil || err != nil {
+			t.Fatal("A prefix matching no nodes should return (nil, nil), got", results, err)
+		}
+		if _, err := tree.FindByPrefix([]float64{1, 2, 3, 4}); err != ErrDimensionMismatch {
+			t.Fatal("A prefix longer than the tree's dimensions should return ErrDimensionMismatch, got", err)
+		}
+		if results, err := tree.FindByPrefix([]float64{math.NaN()}); results != nil || err != nil {
+			t.Fatal("A prefix containing NaN should match nothing, got", results, err)
+		}
+	}
+}
+
+func TestFindWithinRadius(t *testing.T) {
+	nl := genlist(6, 20000)
+	tree := BuildTree(nl)
+
+	for i := 0; i < 100; i++ {
+		coords := rndCoords(6)
+		radius := rand.Float64() / 2
+		results1, err := tree.FindWithinRadius(coords, radius)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results2 := make([]*Node, 0, len(nl))
+		for _, n := range nl {
+			if (EuclideanMetric{}).Distance(coords, n.Coordinates) <= radius {
+				results2 = append(results2, n)
+			}
+		}
+
+		if len(results1) != len(results2) {
+			t.Fatal("Tree FindWithinRadius returned", len(results1), "nodes, list search returned", len(results2))
+		}
+		if count, _ := tree.CountWithinRadius(coords, radius); count != len(results2) {
+			t.Fatal("Tree CountWithinRadius returned", count, "list search found", len(results2))
+		}
+		for _, n := range results1 {
+			if _, ok := find_nl(results2, n); !ok {
+				t.Fatal("Node from tree results not found in results list:", n)
+			}
+		}
+	}
+
+	if results, err := tree.FindWithinRadius(rndCoords(6), -1); results != nil || err != nil {
+		t.Fatal("Searching with a negative radius should return (nil, nil).")
+	}
+	if _, err := tree.FindWithinRadius(rndCoords(5), 0.1); err == nil {
+		t.Fatal("Searching with the wrong number of dimensions should return an error.")
+	}
+	if _, err := tree.CountWithinRadius(rndCoords(5), 0.1); err == nil {
+		t.Fatal("Counting with the wrong number of dimensions should return an error.")
+	}
+	if count, _ := BuildTreeBucket(genlist(6, 100), 8).CountWithinRadius(rndCoords(6), 10); count != 100 {
+		t.Fatal("CountWithinRadius on a bucket tree returned", count, "expected 100")
+	}
+
+	// node 0 is exactly radius away the other way around the wrapped axis, on the left of every split
+	wrapped := BuildTree([]*Node{NewNode([]float64{0}), NewNode([]float64{5}), NewNode([]float64{7}), NewNode([]float64{9})})
+	wrapped.Metric = WrappedMetric{Period: []float64{10}}
+	if results, _ := wrapped.FindWithinRadius([]float64{8}, 2); len(results) != 3 {
+		t.Fatal("FindWithinRadius on a wrapped axis returned", len(results), "nodes, expected 3")
+	}
+	if count, _ := wrapped.CountWithinRadius([]float64{8}, 2); count != 3 {
+		t.Fatal("CountWithinRadius on a wrapped axis returned", count, "expected 3")
+	}
+}
+
+func BenchmarkFindWithinRadius(b *testing.B) {
+	b.StopTimer()
+	nl := genlist(6, b.N*2)
+	tree := BuildTree(nl)
+	b.StartTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := tree.FindWithinRadius(rndCoords(6), 0.2); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestCountRange(t *testing.T) {
+	nl := genlist(6, 20000)
+	tree := BuildTree(nl)
+
+	for i := 0; i < 100; i++ {
+		ranges := make(map[int]Range)
+		for axis := rand.Intn(6); len(ranges) < rand.Intn(6)+1; axis = rand.Intn(6) {
+			ranges[axis], _ = NewRange(rand.Float64(), rand.Float64())
+		}
+		results, err := tree.FindRange(ranges)
+		if err != nil {
+			t.Fatal(err)
+		}
+		count, err := tree.CountRange(ranges)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != len(results) {
+			t.Fatal("CountRange returned", count, "FindRange returned", len(results), "nodes")
+		}
+	}
+
+	if _, err := tree.CountRange(map[int]Range{6: Range{0, 1}}); err == nil {
+		t.Fatal("Counting a range outside of the tree's dimensions should return an error.")
+	}
+}
+
+func BenchmarkCountRange(b *testing.B) {
+	b.StopTimer()
+	nl := genlist(6, b.N*2)
+	tree := BuildTree(nl)
+	b.StartTimer()
+
+	for i := 0; i < b.N; i++ {
+		ranges := make(map[int]Range)
+		for axis := rand.Intn(6); len(ranges) < 2; axis = rand.Intn(6) {
+			ranges[axis], _ = NewRange(rand.Float64(), rand.Float64())
+		}
+		if _, err := tree.CountRange(ranges); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestFindMinMax(t *testing.T) {
+	nl := genlist(4, 10000)
+	tree := BuildTree(nl)
+	for axis := 0; axis < 4; axis++ {
+		min, max := nl[0], nl[0]
+		for _, n := range nl {
+			if n.Coordinates[axis] < min.Coordinates[axis] {
+				min = n
+			}
+			if n.Coordinates[axis] > max.Coordinates[axis] {
+				max = n
+			}
+		}
+		if n, err := tree.FindMin(axis); err != nil || n != min {
+			t.Fatal("Minimum on axis", axis, "should be", min, "found", n, err)
+		}
+		if n, err := tree.FindMax(axis); err != nil || n != max {
+			t.Fatal("Maximum on axis", axis, "should be", max, "found", n, err)
+		}
+	}
+
+	if _, err := tree.FindMin(4); err == nil {
+		t.Fatal("Searching an axis outside of the tree's dimensions should return an error.")
+	}
+	if _, err := tree.FindMax(-1); err == nil {
+		t.Fatal("Searching a negative axis should return an error.")
+	}
+	if n, err := new(Tree).FindMax(0); n != nil || err != nil {
+		t.Fatal("Searching an empty tree should return (nil, nil).")
+	}
+}
+
+func TestNearestOnAxis(t *testing.T) {
+	nl := genlist(3, 5000)
+	tree := BuildTree(nl)
+	for i := 0; i < 200; i++ {
+		axis, value := rand.Intn(3), rand.Float64()*1.2-0.1
+		n, err := tree.NearestOnAxis(axis, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		best := math.Inf(1)
+		for _, o := range nl {
+			best = math.Min(best, math.Abs(o.Coordinates[axis]-value))
+		}
+		if d := math.Abs(n.Coordinates[axis] - value); d != best {
+			t.Fatal("Nearest on axis", axis, "to", value, "is", n, "at distance", d, "expected", best)
+		}
+	}
+
+	if _, err := tree.NearestOnAxis(3, 0); err == nil {
+		t.Fatal("Searching an axis outside of the tree's dimensions should return an error.")
+	}
+	if n, err := new(Tree).NearestOnAxis(0, 0); n != nil || err != nil {
+		t.Fatal("Searching an empty tree should return (nil, nil).")
+	}
+}
+
+func TestBounds(t *testing.T) {
+	nl := genlist(6, 10000)
+	tree := BuildTree(nl)
+	bounds, err := tree.Bounds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bounds) != 6 {
+		t.Fatal("Bounds returned", len(bounds), "ranges for a 6 dimensional tree.")
+	}
+	for axis, r := range bounds {
+		min, max := math.Inf(1), math.Inf(-1)
+		for _, n := range nl {
+			min = math.Min(min, n.Coordinates[axis])
+			max = math.Max(max, n.Coordinates[axis])
+		}
+		if r.Min != min || r.Max != max {
+			t.Fatal("Bounds on axis", axis, "are", r, "expected", Range{min, max})
+		}
+	}
+
+	if bounds, err := new(Tree).Bounds(); bounds != nil || err != nil {
+		t.Fatal("Bounds of an empty tree should be (nil, nil).")
+	}
+}
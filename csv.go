@@ -0,0 +1,89 @@
+// Copyright 2012 by Graeme Humphries <graeme@sudo.ca>
+//
+// kdtree is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// kdtree is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with kdtree.  If not, see http://www.gnu.org/licenses/.
+
+package kdtree
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+)
+
+/***** CSV Import and Export Functions *****/
+
+// Builds a balanced tree, as BuildTree does, from CSV rows of numbers read from r. The first
+// dimensions columns of each row are parsed as the coordinates of a node, and any further columns
+// are ignored. Each node's Index is set to the index of its row, counting from 0.
+// Returns (nil, error) if dimensions < 1, or if any row has fewer than dimensions columns or a
+// column which isn't a number, including NaN, which is rejected as it is by Add.
+func LoadCSV(r io.Reader, dimensions int) (*Tree, error) {
+	if dimensions < 1 {
+		return nil, errors.New("Trees must have at least 1 dimension.")
+	}
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1 // rows are checked below, so they can have extra columns
+	cr.ReuseRecord = true
+
+	var nodes []*Node
+	for row := 0; ; row++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if len(record) < dimensions {
+			return nil, errors.New("Row " + strconv.Itoa(row+1) + " has " + strconv.Itoa(len(record)) + " columns, expected at least " + strconv.Itoa(dimensions) + ".")
+		}
+
+		coords := make([]float64, dimensions)
+		for i := range coords {
+			if coords[i], err = strconv.ParseFloat(record[i], 64); err != nil || math.IsNaN(coords[i]) {
+				return nil, errors.New("Row " + strconv.Itoa(row+1) + ", column " + strconv.Itoa(i+1) + ": " + strconv.Quote(record[i]) + " is not a number.")
+			}
+		}
+		n := NewNode(coords)
+		n.Index = row
+		nodes = append(nodes, n)
+	}
+	return BuildTree(nodes), nil
+}
+
+// Writes a CSV row to w for every node in the Tree, in the order Traverse visits them, with the
+// node's coordinates followed by its Index. Coordinates are written with as many digits as needed to
+// read back exactly, so LoadCSV with the tree's dimensions rebuilds a tree of the same points.
+func (t *Tree) WriteCSV(w io.Writer) error {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	cw := csv.NewWriter(w)
+	var record []string
+	var err error
+	t.Root.each(func(n *Node) bool {
+		record = record[:0]
+		for _, c := range n.Coordinates {
+			record = append(record, strconv.FormatFloat(c, 'g', -1, 64))
+		}
+		record = append(record, strconv.Itoa(n.Index))
+		err = cw.Write(record)
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
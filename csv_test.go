@@ -0,0 +1,88 @@
+// Copyright 2012 by Graeme Humphries <graeme@sudo.ca>
+//
+// kdtree is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// kdtree is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with kdtree.  If not, see http://www.gnu.org/licenses/.
+
+package kdtree
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLoadCSV(t *testing.T) {
+	data := "1,2,3,label\n4.5,-6,7e2,other\n0,0,0\n"
+	tree, err := LoadCSV(strings.NewReader(data), 3)
+	if err != nil {
+		t.Fatal("Failed to load CSV: " + err.Error())
+	}
+	if size := tree.Size(); size != 3 {
+		t.Fatal("Tree loaded from CSV has", size, "nodes, expected 3")
+	}
+	expected := [][]float64{{1, 2, 3}, {4.5, -6, 700}, {0, 0, 0}}
+	for row, coords := range expected {
+		if n, err := tree.Find(coords); err != nil || n == nil {
+			t.Fatal("Row", row, String(coords), "not found!")
+		} else if n.Index != row {
+			t.Fatal("Row", row, "has Index", n.Index)
+		}
+	}
+
+	if tree, err := LoadCSV(strings.NewReader(""), 2); err != nil || tree.Root != nil {
+		t.Fatal("Loading an empty CSV should give an empty tree, got error", err)
+	}
+	for _, bad := range []string{"1,2\n3\n", "1,2\n3,x\n", "1,\"2\n", "1,2\nNaN,3\n"} {
+		if _, err := LoadCSV(strings.NewReader(bad), 2); err == nil {
+			t.Fatal("Loading malformed CSV", strconv.Quote(bad), "should return an error.")
+		}
+	}
+	if _, err := LoadCSV(strings.NewReader(data), 0); err == nil {
+		t.Fatal("Loading a tree with 0 dimensions should return an error.")
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	nl := genlist(3, 1000)
+	for i, n := range nl {
+		n.Index = i
+	}
+	tree := BuildTree(nl)
+
+	buf := new(strings.Builder)
+	if err := tree.WriteCSV(buf); err != nil {
+		t.Fatal("Failed to write CSV: " + err.Error())
+	}
+	rows := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(rows) != len(nl) {
+		t.Fatal("WriteCSV wrote", len(rows), "rows, expected", len(nl))
+	}
+	i := 0
+	tree.Traverse(func(n *Node) {
+		index := strconv.Itoa(n.Index)
+		if !strings.HasSuffix(rows[i], ","+index) {
+			t.Fatal("Row", i, "is", rows[i], "expected", n.String(), "with Index", index)
+		}
+		i++
+	})
+
+	loaded, err := LoadCSV(strings.NewReader(buf.String()), 3)
+	if err != nil {
+		t.Fatal("Failed to load CSV: " + err.Error())
+	}
+	for _, n := range nl {
+		if found, err := loaded.Find(n.Coordinates); err != nil || found == nil {
+			t.Fatal(n.String(), "not found after loading written CSV!")
+		}
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2012 by Graeme Humphries <graeme@sudo.ca>
+//
+// kdtree is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// kdtree is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with kdtree.  If not, see http://www.gnu.org/licenses/.
+
+package kdtree
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+)
+
+/***** Tree Visualization Functions *****/
+
+// Writes the Tree to w as a GraphViz digraph, which can be rendered with e.g. `dot -Tpng`.
+// Each node is labelled with its coordinates and splitting axis, with edges to its left and
+// right children labelled "L" and "R", and dashed edges to any nodes in its bucket.
+func (t *Tree) ToDOT(w io.Writer) error {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString("digraph kdtree {\n")
+	if t.Root != nil {
+		id := 0
+		t.Root.writeDOT(bw, &id)
+	}
+	bw.WriteString("}\n")
+	// bufio.Writer keeps the first error, and returns it from Flush
+	return bw.Flush()
+}
+
+// Writes this (sub)tree's nodes and edges to w, numbering nodes in pre-order starting at *id.
+// Returns the number given to this node.
+func (n *Node) writeDOT(w *bufio.Writer, id *int) int {
+	me := *id
+	*id++
+	name := "n" + strconv.Itoa(me)
+	w.WriteString("\t" + name + " [label=\"" + String(n.Coordinates) + "\\naxis = " + strconv.Itoa(n.axis) + "\"];\n")
+
+	for i, child := range []*Node{n.leftChild, n.rightChild} {
+		if child == nil {
+			continue
+		}
+		label := "L"
+		if i == 1 {
+			label = "R"
+		}
+		w.WriteString("\t" + name + " -> n" + strconv.Itoa(child.writeDOT(w, id)) + " [label=\"" + label + "\"];\n")
+	}
+	for _, b := range n.bucket {
+		w.WriteString("\t" + name + " -> n" + strconv.Itoa(b.writeDOT(w, id)) + " [style=dashed];\n")
+	}
+	return me
+}
@@ -0,0 +1,56 @@
+// Copyright 2012 by Graeme Humphries <graeme@sudo.ca>
+//
+// kdtree is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// kdtree is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with kdtree.  If not, see http://www.gnu.org/licenses/.
+
+package kdtree
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestToDOT(t *testing.T) {
+	tree := new(Tree)
+	for _, coords := range [][]float64{{1, 1}, {0, 0}, {2, 2}} {
+		if err := tree.Add(NewNode(coords)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf := new(bytes.Buffer)
+	if err := tree.ToDOT(buf); err != nil {
+		t.Fatal("Failed to write DOT: " + err.Error())
+	}
+	expected := `digraph kdtree {
+	n0 [label="( 1 1 )\naxis = 0"];
+	n1 [label="( 0 0 )\naxis = 1"];
+	n0 -> n1 [label="L"];
+	n2 [label="( 2 2 )\naxis = 1"];
+	n0 -> n2 [label="R"];
+}
+`
+	if buf.String() != expected {
+		t.Fatal("ToDOT wrote:\n" + buf.String() + "expected:\n" + expected)
+	}
+
+	// every node except the root should have one edge leading to it
+	tree = BuildTree(genlist(6, 1000))
+	buf.Reset()
+	if err := tree.ToDOT(buf); err != nil {
+		t.Fatal("Failed to write DOT: " + err.Error())
+	}
+	if edges := strings.Count(buf.String(), "->"); edges != 999 {
+		t.Fatal("ToDOT wrote", edges, "edges for 1000 nodes.")
+	}
+}
@@ -0,0 +1,470 @@
+// Copyright 2012 by Graeme Humphries <graeme@sudo.ca>
+//
+// kdtree is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// kdtree is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with kdtree.  If not, see http://www.gnu.org/licenses/.
+
+package kdtree
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+)
+
+/***** Tree Serialization Functions *****/
+
+// Flattened Node used to serialize trees. Children are referenced by their index in the
+// serialized list of nodes, with 0 meaning no child, as the root at index 0 can't be a child.
+// Nodes in a bucket are referenced in the same way.
+type gobNode struct {
+	Coordinates []float64
+	Axis        int
+	Index       int
+	Fare        uint16 // only set in trees encoded before Index replaced Fare
+	Value       interface{}
+	Left, Right int
+	Bucket      []int
+}
+
+// Encodes the Tree's nodes and structure for encoding/gob, so a Tree can be saved and
+// loaded without being rebuilt. Node Values are encoded as interfaces, so their concrete
+// types must be registered with gob.Register. The Tree's Metric is not encoded.
+func (t *Tree) GobEncode() ([]byte, error) {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+
+	nodes := make([]gobNode, 0, 100)
+	t.Root.flatten(&nodes)
+
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(nodes); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decodes a Tree encoded by GobEncode, replacing any nodes already in this Tree.
+func (t *Tree) GobDecode(data []byte) error {
+	var nodes []gobNode
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&nodes); err != nil {
+		return err
+	}
+	root, err := unflatten(nodes)
+	if err != nil {
+		return err
+	}
+
+	t.Mutex.Lock()
+	defer t.Mutex.Unlock()
+	root.setHeights()
+	t.Root = root
+	t.count = root.size()
+	return nil
+}
+
+// Appends this (sub)tree to nodes in pre-order, returning the index of this node, or 0 if it's nil.
+func (n *Node) flatten(nodes *[]gobNode) int {
+	if n == nil {
+		return 0
+	}
+	i := len(*nodes)
+	*nodes = append(*nodes, gobNode{Coordinates: n.Coordinates, Axis: n.axis, Index: n.Index, Value: n.Value})
+	left := n.leftChild.flatten(nodes)
+	right := n.rightChild.flatten(nodes)
+	(*nodes)[i].Left, (*nodes)[i].Right = left, right
+	for _, b := range n.bucket {
+		j := b.flatten(nodes)
+		(*nodes)[i].Bucket = append((*nodes)[i].Bucket, j)
+	}
+	return i
+}
+
+// Rebuilds a tree from a list of flattened nodes, linking children and parents.
+// Returns the root of the tree, or an error if the child indices don't describe a tree, or the
+// nodes don't all have the same dimensions.
+func unflatten(nodes []gobNode) (*Node, error) {
+	if len(nodes) == 0 {
+		return nil, nil
+	}
+	list := make([]*Node, len(nodes))
+	for i, gn := range nodes {
+		n := NewNodeWithValue(gn.Coordinates, gn.Value)
+		n.Index = gn.Index
+		if gn.Fare != 0 {
+			n.Index = int(gn.Fare)
+		}
+		n.axis = gn.Axis
+		if len(n.Coordinates) != len(nodes[0].Coordinates) {
+			return nil, errors.New("Node " + strconv.Itoa(i) + " has " + strconv.Itoa(len(n.Coordinates)) + " dimensions, node 0 has " + strconv.Itoa(len(nodes[0].Coordinates)) + ".")
+		}
+		if n.axis < 0 || n.axis >= len(n.Coordinates) {
+			return nil, errors.New("Node " + strconv.Itoa(i) + " has axis " + strconv.Itoa(n.axis) + " outside of its dimensions.")
+		}
+		list[i] = n
+	}
+	// each node except the root must be the child of exactly one node
+	for i, gn := range nodes {
+		for c, child := range append([]int{gn.Left, gn.Right}, gn.Bucket...) {
+			if child == 0 && c < 2 {
+				continue
+			}
+			if child <= 0 || child >= len(list) || list[child].parent != nil {
+				return nil, errors.New("Node " + strconv.Itoa(i) + " has an invalid child index " + strconv.Itoa(child) + ".")
+			}
+			list[child].parent = list[i]
+			switch c {
+			case 0:
+				list[i].leftChild = list[child]
+			case 1:
+				list[i].rightChild = list[child]
+			default:
+				list[i].bucket = append(list[i].bucket, list[child])
+			}
+		}
+	}
+	return list[0], nil
+}
+
+// Maximum depth of a Tree that can be encoded to JSON. Each level of the tree is a nested
+// JSON object, and encoding/json refuses to decode objects nested more than 10000 deep.
+// Encoding also stops at this depth rather than following a cycle in corrupted child links.
+const MaxJSONDepth = 9000
+
+// Node as encoded to JSON, with its children and any bucket nested inside it.
+type jsonNode struct {
+	Coordinates []float64   `json:"coordinates"`
+	Index       int         `json:"index"`
+	Fare        uint16      `json:"fare,omitempty"` // only set in trees encoded before Index replaced Fare
+	Axis        int         `json:"axis"`
+	Left        *jsonNode   `json:"left"`
+	Right       *jsonNode   `json:"right"`
+	Bucket      []*jsonNode `json:"bucket,omitempty"`
+}
+
+// Encodes the Tree as JSON, with each node as an object of the form
+//
+//	{"coordinates":[...],"index":N,"axis":A,"left":{...},"right":{...}}
+//
+// where missing children are null. A node with a bucket also has a "bucket" list of the nodes
+// in it. An empty tree is encoded as null. Trees encoded with a "fare" rather than an "index" for
+// each node, before Index replaced Fare, can still be decoded. Node Values and the
+// Tree's Metric are not encoded. Returns an error if the tree is deeper than MaxJSONDepth.
+func (t *Tree) MarshalJSON() ([]byte, error) {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	root, err := t.Root.toJSON(1)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(root)
+}
+
+// Decodes a Tree encoded by MarshalJSON, replacing any nodes already in this Tree.
+func (t *Tree) UnmarshalJSON(data []byte) error {
+	var jn *jsonNode
+	if err := json.Unmarshal(data, &jn); err != nil {
+		return err
+	}
+	root, err := jn.toNode(nil)
+	if err != nil {
+		return err
+	}
+
+	t.Mutex.Lock()
+	defer t.Mutex.Unlock()
+	root.setHeights()
+	t.Root = root
+	t.count = root.size()
+	return nil
+}
+
+// Converts this (sub)tree at depth to its JSON representation.
+func (n *Node) toJSON(depth int) (*jsonNode, error) {
+	if n == nil {
+		return nil, nil
+	}
+	if depth > MaxJSONDepth {
+		return nil, errors.New("Tree is deeper than " + strconv.Itoa(MaxJSONDepth) + " levels, and can't be encoded to JSON.")
+	}
+	left, err := n.leftChild.toJSON(depth + 1)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.rightChild.toJSON(depth + 1)
+	if err != nil {
+		return nil, err
+	}
+	jn := &jsonNode{Coordinates: n.Coordinates, Index: n.Index, Axis: n.axis, Left: left, Right: right}
+	for _, b := range n.bucket {
+		jb, err := b.toJSON(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		jn.Bucket = append(jn.Bucket, jb)
+	}
+	return jn, nil
+}
+
+// Converts a decoded JSON (sub)tree to Nodes, linking them to parent. Returns an error if any node
+// doesn't have the same dimensions as its parent, so every node has the root's dimensions.
+func (jn *jsonNode) toNode(parent *Node) (*Node, error) {
+	if jn == nil {
+		return nil, nil
+	}
+	if parent != nil && len(jn.Coordinates) != len(parent.Coordinates) {
+		return nil, errors.New("Node " + String(jn.Coordinates) + " has " + strconv.Itoa(len(jn.Coordinates)) + " dimensions, its parent has " + strconv.Itoa(len(parent.Coordinates)) + ".")
+	}
+	if jn.Axis < 0 || jn.Axis >= len(jn.Coordinates) {
+		return nil, errors.New("Node " + String(jn.Coordinates) + " has axis " + strconv.Itoa(jn.Axis) + " outside of its dimensions.")
+	}
+	n := NewNode(jn.Coordinates)
+	n.Index = jn.Index
+	if jn.Fare != 0 {
+		n.Index = int(jn.Fare)
+	}
+	n.axis = jn.Axis
+	n.parent = parent
+
+	var err error
+	if n.leftChild, err = jn.Left.toNode(n); err != nil {
+		return nil, err
+	}
+	if n.rightChild, err = jn.Right.toNode(n); err != nil {
+		return nil, err
+	}
+	for _, jb := range jn.Bucket {
+		if jb == nil {
+			return nil, errors.New("Node " + n.String() + " has a null node in its bucket.")
+		}
+		b, err := jb.toNode(n)
+		if err != nil {
+			return nil, err
+		}
+		n.bucket = append(n.bucket, b)
+	}
+	return n, nil
+}
+
+// Binary tree format written by WriteTo and read by ReadTree. Streams start with binaryMagic,
+// a version byte, and the number of dimensions as a uint32, followed by each node in pre-order.
+// A node is a flags byte (binaryHasLeft | binaryHasRight | binaryHasBucket), its axis as a uint32,
+// Index as an int64, then its coordinates as float64s. If binaryHasBucket is set, the node is followed
+// by the number of nodes in its bucket as a uint32, then those nodes, before its children.
+// All values are little endian.
+// An empty tree is written as a header with 0 dimensions and no nodes. Version 1 of the format,
+// written before Index replaced Fare, had each node's Fare as a uint16 in place of its Index, and can
+// still be read.
+const (
+	binaryMagic     = "KDTR"
+	binaryVersion   = 2
+	binaryHasLeft   = 1 << 0
+	binaryHasRight  = 1 << 1
+	binaryHasBucket = 1 << 2
+)
+
+// Maximum number of dimensions of a Tree that can be written by WriteTo or read by ReadTree. The
+// dimensions are read from the stream before any node, and size the buffer each node is read into,
+// so larger counts are rejected rather than trusted from corrupted or malicious data.
+const MaxBinaryDimensions = 1 << 16
+
+// Writes the Tree to w in a compact binary format that can be read back by ReadTree, one
+// node at a time so the whole tree never needs to be held in memory twice.
+// Node Values and the Tree's Metric are not written. Returns the number of bytes written.
+// Returns an error without writing anything if the tree has more than MaxBinaryDimensions dimensions.
+func (t *Tree) WriteTo(w io.Writer) (int64, error) {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+
+	bw := &binaryWriter{w: bufio.NewWriter(w)}
+	dimensions := 0
+	if t.Root != nil {
+		dimensions = len(t.Root.Coordinates)
+	}
+	if dimensions > MaxBinaryDimensions {
+		return 0, errors.New("Tree has more than " + strconv.Itoa(MaxBinaryDimensions) + " dimensions, and can't be written in binary.")
+	}
+	header := make([]byte, len(binaryMagic)+5)
+	copy(header, binaryMagic)
+	header[len(binaryMagic)] = binaryVersion
+	binary.LittleEndian.PutUint32(header[len(binaryMagic)+1:], uint32(dimensions))
+	bw.write(header)
+
+	if t.Root != nil {
+		t.Root.writeTo(bw, make([]byte, binaryNodeHeader(binaryVersion)+8*dimensions))
+	}
+	if bw.err == nil {
+		bw.err = bw.w.Flush()
+	}
+	return bw.n, bw.err
+}
+
+// Writes this (sub)tree to bw in pre-order, using buf to hold each encoded node.
+func (n *Node) writeTo(bw *binaryWriter, buf []byte) {
+	if n == nil || bw.err != nil {
+		return
+	}
+	if len(buf) != binaryNodeHeader(binaryVersion)+8*len(n.Coordinates) {
+		bw.err = ErrDimensionMismatch
+		return
+	}
+
+	var flags byte
+	if n.leftChild != nil {
+		flags |= binaryHasLeft
+	}
+	if n.rightChild != nil {
+		flags |= binaryHasRight
+	}
+	if len(n.bucket) > 0 {
+		flags |= binaryHasBucket
+	}
+	buf[0] = flags
+	binary.LittleEndian.PutUint32(buf[1:], uint32(n.axis))
+	binary.LittleEndian.PutUint64(buf[5:], uint64(n.Index))
+	for i, c := range n.Coordinates {
+		binary.LittleEndian.PutUint64(buf[13+8*i:], math.Float64bits(c))
+	}
+	bw.write(buf)
+
+	if len(n.bucket) > 0 {
+		var count [4]byte
+		binary.LittleEndian.PutUint32(count[:], uint32(len(n.bucket)))
+		bw.write(count[:])
+		for _, b := range n.bucket {
+			b.writeTo(bw, buf)
+		}
+	}
+	n.leftChild.writeTo(bw, buf)
+	n.rightChild.writeTo(bw, buf)
+}
+
+// Writer that counts bytes written and remembers the first error, so encoding can stop early.
+type binaryWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (bw *binaryWriter) write(p []byte) {
+	if bw.err != nil {
+		return
+	}
+	n, err := bw.w.Write(p)
+	bw.n += int64(n)
+	bw.err = err
+}
+
+// Reads a Tree written by Tree.WriteTo from r. Returns an error if r doesn't contain a tree
+// in a supported version of the format, or has more than MaxBinaryDimensions dimensions.
+func ReadTree(r io.Reader) (*Tree, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(binaryMagic)+5)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, err
+	}
+	if string(header[:len(binaryMagic)]) != binaryMagic {
+		return nil, errors.New("Data is not a binary k-d tree.")
+	}
+	version := header[len(binaryMagic)]
+	if version < 1 || version > binaryVersion {
+		return nil, errors.New("Unsupported binary k-d tree version " + strconv.Itoa(int(version)) + ".")
+	}
+	dimensions := int(binary.LittleEndian.Uint32(header[len(binaryMagic)+1:]))
+	if dimensions > MaxBinaryDimensions {
+		return nil, errors.New("Binary k-d tree has " + strconv.Itoa(dimensions) + " dimensions, more than the maximum of " + strconv.Itoa(MaxBinaryDimensions) + ".")
+	}
+
+	tree := new(Tree)
+	if dimensions == 0 {
+		return tree, nil
+	}
+	root, err := readNode(br, make([]byte, binaryNodeHeader(version)+8*dimensions), version, nil)
+	if err != nil {
+		return nil, err
+	}
+	root.setHeights()
+	tree.Root = root
+	tree.count = root.size()
+	return tree, nil
+}
+
+// Returns the number of bytes before the coordinates of each node in version of the binary format.
+func binaryNodeHeader(version byte) int {
+	if version == 1 {
+		return 7 // flags, axis and a uint16 Fare
+	}
+	return 13 // flags, axis and an int64 Index
+}
+
+// Reads a (sub)tree written in pre-order by Node.writeTo in version of the binary format from r,
+// linking it to parent.
+func readNode(r io.Reader, buf []byte, version byte, parent *Node) (*Node, error) {
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	flags := buf[0]
+	header := binaryNodeHeader(version)
+	coords := make([]float64, (len(buf)-header)/8)
+	for i := range coords {
+		coords[i] = math.Float64frombits(binary.LittleEndian.Uint64(buf[header+8*i:]))
+	}
+	n := NewNode(coords)
+	n.axis = int(binary.LittleEndian.Uint32(buf[1:]))
+	if version == 1 {
+		n.Index = int(binary.LittleEndian.Uint16(buf[5:]))
+	} else {
+		n.Index = int(int64(binary.LittleEndian.Uint64(buf[5:])))
+	}
+	n.parent = parent
+	if n.axis >= len(coords) {
+		return nil, errors.New("Node " + n.String() + " has an axis outside of its dimensions.")
+	}
+
+	var err error
+	if flags&binaryHasBucket != 0 {
+		var count [4]byte
+		if _, err := io.ReadFull(r, count[:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		for i := binary.LittleEndian.Uint32(count[:]); i > 0; i-- {
+			b, err := readNode(r, buf, version, n)
+			if err != nil {
+				return nil, err
+			}
+			n.bucket = append(n.bucket, b)
+		}
+	}
+	if flags&binaryHasLeft != 0 {
+		if n.leftChild, err = readNode(r, buf, version, n); err != nil {
+			return nil, err
+		}
+	}
+	if flags&binaryHasRight != 0 {
+		if n.rightChild, err = readNode(r, buf, version, n); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
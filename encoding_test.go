@@ -0,0 +1,282 @@
+// Copyright 2012 by Graeme Humphries <graeme@sudo.ca>
+//
+// kdtree is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// kdtree is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with kdtree.  If not, see http://www.gnu.org/licenses/.
+
+package kdtree
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Checks that tree holds exactly the nodes in nl, each found with matching coordinates and Index,
+// and that tree's parent pointers are consistent. The nodes in tree may be copies of those in nl.
+func checkCopy(t *testing.T, tree *Tree, nl []*Node) {
+	if err := tree.Validate(); err != nil {
+		t.Fatal("Tree is not valid: " + err.Error())
+	}
+	if tree.Root.parent != nil {
+		t.Fatal("Root " + tree.Root.String() + " has a parent.")
+	}
+	if size := tree.Size(); size != len(nl) {
+		t.Fatal("Decoded tree has", size, "nodes, expected", len(nl))
+	}
+	if depth := tree.Depth(); depth != tree.Root.depth() {
+		t.Fatal("Decoded tree has Depth", depth, "but is", tree.Root.depth(), "deep")
+	}
+	tree.Traverse(func(n *Node) {
+		for _, c := range []*Node{n.leftChild, n.rightChild} {
+			if c != nil && c.parent != n {
+				t.Fatal(c.String() + " does not have " + n.String() + " as parent.")
+			}
+		}
+	})
+	for k, n := range nl {
+		search, err := tree.Find(n.Coordinates)
+		if err != nil {
+			t.Fatal("Error while searching tree:", err)
+		} else if search == nil {
+			t.Fatal(strconv.FormatInt(int64(k), 10) + ": " + n.String() + " not found!")
+		} else if search.Index != n.Index {
+			t.Fatal(strconv.FormatInt(int64(k), 10)+": "+n.String()+" has Index", search.Index, "expected", n.Index)
+		}
+	}
+}
+
+func TestGob(t *testing.T) {
+	nl := genlist(6, 10000)
+	for i, n := range nl {
+		n.Index = i
+	}
+	tree := BuildTree(nl)
+
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(tree); err != nil {
+		t.Fatal("Failed to encode tree: " + err.Error())
+	}
+	decoded := new(Tree)
+	if err := gob.NewDecoder(buf).Decode(decoded); err != nil {
+		t.Fatal("Failed to decode tree: " + err.Error())
+	}
+	checkCopy(t, decoded, nl)
+
+	buf.Reset()
+	mixed := []gobNode{{Coordinates: []float64{1, 2}, Right: 1}, {Coordinates: []float64{3}}}
+	if err := gob.NewEncoder(buf).Encode(mixed); err != nil {
+		t.Fatal("Failed to encode nodes: " + err.Error())
+	}
+	if err := new(Tree).GobDecode(buf.Bytes()); err == nil {
+		t.Fatal("Decoding nodes with differing dimensions should return an error.")
+	}
+}
+
+func TestJSON(t *testing.T) {
+	nl := genlist(6, 10000)
+	for i, n := range nl {
+		n.Index = i
+	}
+	tree := BuildTree(nl)
+
+	data, err := json.Marshal(tree)
+	if err != nil {
+		t.Fatal("Failed to encode tree: " + err.Error())
+	}
+	decoded := new(Tree)
+	if err := json.Unmarshal(data, decoded); err != nil {
+		t.Fatal("Failed to decode tree: " + err.Error())
+	}
+	checkCopy(t, decoded, nl)
+
+	single := BuildTree([]*Node{NewNode([]float64{1, 2.5})})
+	single.Root.Index = 3
+	expected := `{"coordinates":[1,2.5],"index":3,"axis":0,"left":null,"right":null}`
+	if data, err := json.Marshal(single); err != nil {
+		t.Fatal("Failed to encode tree: " + err.Error())
+	} else if string(data) != expected {
+		t.Fatal("Tree encoded as " + string(data) + ", expected " + expected)
+	}
+
+	if err := json.Unmarshal([]byte(`{"coordinates":[1,2],"axis":2}`), new(Tree)); err == nil {
+		t.Fatal("Decoding a node with an invalid axis should return an error.")
+	}
+	mixed := `{"coordinates":[1,2],"axis":0,"left":{"coordinates":[0],"axis":0},"right":null}`
+	if err := json.Unmarshal([]byte(mixed), new(Tree)); err == nil {
+		t.Fatal("Decoding nodes with differing dimensions should return an error.")
+	}
+	mixed = `{"coordinates":[1,2],"axis":0,"bucket":[{"coordinates":[0,1,2],"axis":0}]}`
+	if err := json.Unmarshal([]byte(mixed), new(Tree)); err == nil {
+		t.Fatal("Decoding a bucket with differing dimensions should return an error.")
+	}
+}
+
+func TestWriteTo(t *testing.T) {
+	nl := genlist(6, 10000)
+	for i, n := range nl {
+		n.Index = i
+	}
+	tree := BuildTree(nl)
+
+	buf := new(bytes.Buffer)
+	written, err := tree.WriteTo(buf)
+	if err != nil {
+		t.Fatal("Failed to write tree: " + err.Error())
+	}
+	if written != int64(buf.Len()) {
+		t.Fatal("WriteTo reported", written, "bytes written, buffer has", buf.Len())
+	}
+	decoded, err := ReadTree(buf)
+	if err != nil {
+		t.Fatal("Failed to read tree: " + err.Error())
+	}
+	checkCopy(t, decoded, nl)
+
+	// an empty tree should round trip as an empty tree
+	buf.Reset()
+	if _, err := new(Tree).WriteTo(buf); err != nil {
+		t.Fatal("Failed to write empty tree: " + err.Error())
+	}
+	if decoded, err := ReadTree(buf); err != nil || decoded.Root != nil {
+		t.Fatal("Empty tree did not read back as empty:", err)
+	}
+
+	if _, err := ReadTree(bytes.NewReader([]byte("not a tree"))); err == nil {
+		t.Fatal("Reading data without the binary header should return an error.")
+	}
+
+	// a huge dimension count shouldn't be trusted to size the node buffer
+	if _, err := ReadTree(bytes.NewReader([]byte("KDTR\x02\xff\xff\xff\xff"))); err == nil || !strings.Contains(err.Error(), "dimensions") {
+		t.Fatal("Reading a tree with more than MaxBinaryDimensions dimensions should return an error, got", err)
+	}
+	if _, err := BuildTree([]*Node{NewNode(make([]float64, MaxBinaryDimensions+1))}).WriteTo(new(bytes.Buffer)); err == nil {
+		t.Fatal("Writing a tree with more than MaxBinaryDimensions dimensions should return an error.")
+	}
+}
+
+// Indexes too large for the old uint16 Fare should round trip through every format, and trees
+// encoded with a Fare should decode with it as their Index.
+func TestEncodeIndex(t *testing.T) {
+	n := NewNode([]float64{1, 2})
+	n.Index = 1 << 40
+	tree := BuildTree([]*Node{n})
+	buf := new(bytes.Buffer)
+	if _, err := tree.WriteTo(buf); err != nil {
+		t.Fatal("Failed to write tree: " + err.Error())
+	}
+	binaryTree, err := ReadTree(buf)
+	if err != nil {
+		t.Fatal("Failed to read tree: " + err.Error())
+	}
+	jsonTree, gobTree := new(Tree), new(Tree)
+	if data, err := json.Marshal(tree); err != nil || json.Unmarshal(data, jsonTree) != nil {
+		t.Fatal("Failed to encode tree to JSON:", err)
+	}
+	if data, err := tree.GobEncode(); err != nil || gobTree.GobDecode(data) != nil {
+		t.Fatal("Failed to encode tree to gob:", err)
+	}
+	copies := []*Tree{binaryTree, jsonTree, gobTree}
+
+	old := []byte("KDTR\x01\x02\x00\x00\x00" + "\x00\x00\x00\x00\x00\x07\x00" +
+		"\x00\x00\x00\x00\x00\x00\xf0\x3f" + "\x00\x00\x00\x00\x00\x00\x00\x40")
+	oldBinary, err := ReadTree(bytes.NewReader(old))
+	if err != nil {
+		t.Fatal("Failed to read version 1 tree: " + err.Error())
+	}
+	oldJSON := new(Tree)
+	if err := json.Unmarshal([]byte(`{"coordinates":[1,2],"fare":7,"axis":0,"left":null,"right":null}`), oldJSON); err != nil {
+		t.Fatal("Failed to decode tree with a fare: " + err.Error())
+	}
+	type fareNode struct {
+		Coordinates []float64
+		Axis        int
+		Fare        uint16
+		Left, Right int
+	}
+	oldGob := new(Tree)
+	buf.Reset()
+	if err := gob.NewEncoder(buf).Encode([]fareNode{{Coordinates: []float64{1, 2}, Fare: 7}}); err != nil {
+		t.Fatal("Failed to encode nodes with a Fare: " + err.Error())
+	}
+	if err := oldGob.GobDecode(buf.Bytes()); err != nil {
+		t.Fatal("Failed to decode tree with a Fare: " + err.Error())
+	}
+
+	for i, c := range append(copies, oldBinary, oldJSON, oldGob) {
+		expected := n.Index
+		if i >= len(copies) {
+			expected = 7
+		}
+		if c.Root == nil || !equal_fl(c.Root.Coordinates, n.Coordinates) || c.Root.Index != expected {
+			t.Fatal("Decoded tree", i, "has root", c.Root, "expected", n.String(), "with Index", expected)
+		}
+	}
+}
+
+// Trees built with buckets should keep the same structure, buckets included, in every format.
+func TestEncodeBuckets(t *testing.T) {
+	nl := genlist(3, 1000)
+	for i, n := range nl {
+		n.Index = i
+	}
+	tree := BuildTreeBucket(nl, 6)
+
+	gobTree := new(Tree)
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(tree); err != nil {
+		t.Fatal("Failed to encode tree: " + err.Error())
+	}
+	if err := gob.NewDecoder(buf).Decode(gobTree); err != nil {
+		t.Fatal("Failed to decode tree: " + err.Error())
+	}
+
+	jsonTree := new(Tree)
+	data, err := json.Marshal(tree)
+	if err != nil {
+		t.Fatal("Failed to encode tree: " + err.Error())
+	}
+	if err := json.Unmarshal(data, jsonTree); err != nil {
+		t.Fatal("Failed to decode tree: " + err.Error())
+	}
+
+	buf.Reset()
+	if _, err := tree.WriteTo(buf); err != nil {
+		t.Fatal("Failed to write tree: " + err.Error())
+	}
+	binaryTree, err := ReadTree(buf)
+	if err != nil {
+		t.Fatal("Failed to read tree: " + err.Error())
+	}
+
+	for name, decoded := range map[string]*Tree{"gob": gobTree, "JSON": jsonTree, "binary": binaryTree} {
+		checkCopy(t, decoded, nl)
+		if !decoded.EqualStructure(tree) {
+			t.Fatal("Tree decoded from " + name + " has a different structure to the original.")
+		}
+	}
+}
+
+func BenchmarkWriteTo(b *testing.B) {
+	b.StopTimer()
+	nl := genlist(6, b.N)
+	tree := BuildTree(nl)
+	b.StartTimer()
+
+	if _, err := tree.WriteTo(io.Discard); err != nil {
+		b.Fatal(err)
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2012 by Graeme Humphries <graeme@sudo.ca>
+//
+// kdtree is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// kdtree is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with kdtree.  If not, see http://www.gnu.org/licenses/.
+
+package kdtree
+
+import (
+	"math"
+)
+
+/***** Flat Read-only Trees *****/
+
+// Read-only copy of a Tree laid out in contiguous arrays rather than linked Nodes, so searches
+// read memory in order instead of chasing pointers. Nodes are numbered in pre-order, with any nodes
+// in a node's bucket numbered straight after it, then its left subtree, and each node's coordinates,
+// axis and children are found at its number in each array.
+//
+// A FlatTree never changes, so it can be searched from any number of goroutines without locking.
+// Searches return the Tree's own nodes, but compare the coordinates they had when the Tree was
+// frozen, so later changes to the Tree don't affect a FlatTree.
+type FlatTree struct {
+	dimensions  int
+	coordinates []float64 // node i's coordinates are coordinates[i*dimensions : (i+1)*dimensions]
+	axes        []int32
+	left, right []int32 // child node numbers, -1 if there's no child
+	bucket      []int32 // number of bucket nodes following each node
+	nodes       []*Node // the Tree's node for each node number
+	metric      Metric
+}
+
+// Returns a FlatTree holding the Tree's current nodes and structure, searched with the Tree's
+// current Metric. The Tree is read locked while it's copied.
+func (t *Tree) Freeze() *FlatTree {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	f := &FlatTree{metric: t.metric()}
+	if t.Root == nil {
+		return f
+	}
+
+	f.dimensions = len(t.Root.Coordinates)
+	f.coordinates = make([]float64, 0, t.count*f.dimensions)
+	f.axes = make([]int32, 0, t.count)
+	f.left = make([]int32, 0, t.count)
+	f.right = make([]int32, 0, t.count)
+	f.bucket = make([]int32, 0, t.count)
+	f.nodes = make([]*Node, 0, t.count)
+	f.add(t.Root)
+	return f
+}
+
+// Appends this (sub)tree to f in pre-order, returning its node number, or -1 if n is nil.
+func (f *FlatTree) add(n *Node) int32 {
+	if n == nil {
+		return -1
+	}
+	i := f.addNode(n)
+	f.bucket[i] = int32(len(n.bucket))
+	for _, b := range n.bucket {
+		f.addNode(b)
+	}
+	left := f.add(n.leftChild)
+	right := f.add(n.rightChild)
+	f.left[i], f.right[i] = left, right
+	return i
+}
+
+// Appends node n to f without any children, returning its node number.
+func (f *FlatTree) addNode(n *Node) int32 {
+	i := int32(len(f.nodes))
+	f.coordinates = append(f.coordinates, n.Coordinates...)
+	f.axes = append(f.axes, int32(n.axis))
+	f.left = append(f.left, -1)
+	f.right = append(f.right, -1)
+	f.bucket = append(f.bucket, 0)
+	f.nodes = append(f.nodes, n)
+	return i
+}
+
+// Returns the coordinates of node number i.
+func (f *FlatTree) coords(i int32) []float64 {
+	start := int(i) * f.dimensions
+	return f.coordinates[start : start+f.dimensions]
+}
+
+// Returns number of nodes in the FlatTree.
+func (f *FlatTree) Size() int {
+	return len(f.nodes)
+}
+
+// Performs the same search as Tree.NearestNeighbor on the FlatTree, using the Tree's Metric when
+// it was frozen.
+func (f *FlatTree) NearestNeighbor(coords []float64) (*Node, float64, error) {
+	if len(f.nodes) == 0 {
+		return nil, 0, nil
+	}
+	if err := f.nodes[0].checkDimensions(coords); err != nil {
+		return nil, 0, err
+	}
+
+	s := &flatNearestSearch{f: f, coords: coords, metric: newSearchMetric(f.metric), best: -1, bestDist: math.Inf(1)}
+	s.search(0)
+	return f.nodes[s.best], s.metric.fromReduced(s.bestDist), nil
+}
+
+// State of a search of a FlatTree for the node nearest to coords.
+type flatNearestSearch struct {
+	f        *FlatTree
+	coords   []float64
+	metric   searchMetric
+	best     int32   // closest node number found so far
+	bestDist float64 // reduced distance of best from coords
+}
+
+// Searches the subtree at node number i in the same way as nearestSearch.search.
+func (s *flatNearestSearch) search(i int32) {
+	if i < 0 {
+		return
+	}
+	f := s.f
+	axis := f.axes[i]
+	split := f.coordinates[int(i)*f.dimensions+int(axis)]
+	near, far := f.left[i], f.right[i]
+	if s.coords[axis] >= split {
+		near, far = far, near
+	}
+	s.search(near)
+
+	for j := i; j <= i+f.bucket[i]; j++ {
+		if d := s.metric.distance(s.coords, f.coords(j)); d < s.bestDist {
+			s.best, s.bestDist = j, d
+		}
+	}
+	if s.metric.axisDistance(s.coords[axis], split, int(axis)) < s.bestDist {
+		s.search(far)
+	}
+}
+
+// Performs the same search as Tree.FindRange on the FlatTree.
+func (f *FlatTree) FindRange(ranges map[int]Range) ([]*Node, error) {
+	if len(f.nodes) == 0 {
+		return nil, nil
+	}
+	if err := checkRanges(ranges, f.dimensions); err != nil {
+		return nil, err
+	}
+	result := f.findRange(0, ranges, nil)
+	if len(result) == 0 {
+		return nil, nil
+	}
+	return result, nil
+}
+
+// Appends the nodes in the subtree at node number i matching ranges to result, searching the same
+// subtrees as Node.findRange. Axes in ranges must already be checked.
+func (f *FlatTree) findRange(i int32, ranges map[int]Range, result []*Node) []*Node {
+	if i < 0 {
+		return result
+	}
+	for j := i; j <= i+f.bucket[i]; j++ {
+		if f.inRanges(j, ranges) {
+			result = append(result, f.nodes[j])
+		}
+	}
+
+	axis := int(f.axes[i])
+	split := f.coordinates[int(i)*f.dimensions+axis]
+	r, ok := ranges[axis]
+	if !ok || r.reachesLeft(split) {
+		result = f.findRange(f.left[i], ranges, result)
+	}
+	if !ok || r.reachesRight(split, true) {
+		result = f.findRange(f.right[i], ranges, result)
+	}
+	return result
+}
+
+// Tests whether node number i's coordinates are within every one of ranges.
+func (f *FlatTree) inRanges(i int32, ranges map[int]Range) bool {
+	coords := f.coords(i)
+	for a, r := range ranges {
+		if !r.contains(coords[a], true) {
+			return false
+		}
+	}
+	return true
+}
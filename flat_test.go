@@ -0,0 +1,84 @@
+// Copyright 2012 by Graeme Humphries <graeme@sudo.ca>
+//
+// kdtree is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// kdtree is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with kdtree.  If not, see http://www.gnu.org/licenses/.
+
+package kdtree
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestFreeze(t *testing.T) {
+	for _, tree := range []*Tree{BuildTree(genlist(4, 10000)), BuildTreeBucket(genlist(4, 10000), 6)} {
+		flat := tree.Freeze()
+		if flat.Size() != tree.Size() {
+			t.Fatal("FlatTree has", flat.Size(), "nodes, expected", tree.Size())
+		}
+
+		for i := 0; i < 200; i++ {
+			coords := rndCoords(4)
+			n, dist, err := flat.NearestNeighbor(coords)
+			if err != nil {
+				t.Fatal("Error while searching FlatTree:", err)
+			}
+			expected, expectedDist, _ := tree.NearestNeighbor(coords)
+			if n != expected && dist != expectedDist {
+				t.Fatal("Nearest to " + String(coords) + " should be " + expected.String() + ", found " + n.String())
+			}
+
+			ranges := make(map[int]Range)
+			for axis := rand.Intn(4); len(ranges) < 2; axis = rand.Intn(4) {
+				ranges[axis], _ = NewRange(rand.Float64(), rand.Float64())
+			}
+			results, err := flat.FindRange(ranges)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectedResults, _ := tree.FindRange(ranges)
+			if len(results) != len(expectedResults) {
+				t.Fatal("FlatTree FindRange returned", len(results), "nodes, Tree FindRange returned", len(expectedResults))
+			}
+			for _, n := range results {
+				if _, ok := find_nl(expectedResults, n); !ok {
+					t.Fatal("Node from FlatTree results not found in Tree results:", n)
+				}
+			}
+		}
+
+		if _, _, err := flat.NearestNeighbor(rndCoords(3)); err == nil {
+			t.Fatal("Searching with the wrong number of dimensions should return an error.")
+		}
+		if _, err := flat.FindRange(map[int]Range{4: Range{0, 1}}); err == nil {
+			t.Fatal("Searching a range outside of the tree's dimensions should return an error.")
+		}
+	}
+
+	flat := new(Tree).Freeze()
+	if n, _, err := flat.NearestNeighbor(rndCoords(4)); n != nil || err != nil || flat.Size() != 0 {
+		t.Fatal("Searching an empty FlatTree should return (nil, 0, nil).")
+	}
+}
+
+func BenchmarkFlatNearestNeighbor(b *testing.B) {
+	b.StopTimer()
+	flat := BuildTree(genlist(6, b.N*2)).Freeze()
+	b.StartTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, _, err := flat.NearestNeighbor(rndCoords(6)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
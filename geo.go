@@ -0,0 +1,85 @@
+// Copyright 2012 by Graeme Humphries <graeme@sudo.ca>
+//
+// kdtree is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// kdtree is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with kdtree.  If not, see http://www.gnu.org/licenses/.
+
+package kdtree
+
+import (
+	"errors"
+	"math"
+)
+
+/***** Geographic Trees *****/
+
+// Mean radius of the Earth in kilometers, used to convert angles between points to distances.
+const EarthRadiusKm = 6371.0088
+
+// Tree of points on the Earth's surface, given by latitude and longitude in degrees. Points are
+// stored as 3D Cartesian coordinates on a unit sphere, where the straight-line distance between two
+// points always increases with their great circle distance, so Euclidean nearest neighbor searches
+// of the underlying Tree rank points correctly, even across the poles and the antimeridian.
+type GeoTree struct {
+	Tree *Tree // nodes have 3D unit sphere coordinates, use LatLon to convert them back
+}
+
+// Creates an empty GeoTree. Its Tree always searches with EuclideanMetric, whatever DefaultMetric is,
+// as the unit sphere coordinates rely on it.
+func NewGeoTree() *GeoTree {
+	return &GeoTree{&Tree{Metric: EuclideanMetric{}}}
+}
+
+// Creates a node for a point at lat and lon degrees, carrying v as its Value, and adds it to the
+// GeoTree. Returns an error if lat is outside of [-90, 90], or either isn't a finite number.
+func (g *GeoTree) Add(lat, lon float64, v interface{}) (*Node, error) {
+	coords, err := geoCoords(lat, lon)
+	if err != nil {
+		return nil, err
+	}
+	n := NewNodeWithValue(coords, v)
+	return n, g.Tree.Add(n)
+}
+
+// Searches the GeoTree for the node closest to lat and lon degrees, returning it and its great
+// circle distance in kilometers. Returns (nil, 0, nil) if the tree is empty, or (nil, 0, error)
+// if lat is outside of [-90, 90], or either isn't a finite number.
+func (g *GeoTree) NearestByGreatCircle(lat, lon float64) (*Node, float64, error) {
+	coords, err := geoCoords(lat, lon)
+	if err != nil {
+		return nil, 0, err
+	}
+	n, chord, err := g.Tree.NearestNeighbor(coords)
+	if n == nil {
+		return nil, 0, err
+	}
+	// the chord between two points on a unit sphere is 2 sin(angle/2)
+	return n, 2 * math.Asin(math.Min(chord/2, 1)) * EarthRadiusKm, nil
+}
+
+// Returns the latitude and longitude in degrees of a node in a GeoTree.
+func LatLon(n *Node) (lat, lon float64) {
+	x, y, z := n.Coordinates[0], n.Coordinates[1], n.Coordinates[2]
+	return math.Asin(math.Max(-1, math.Min(z, 1))) * 180 / math.Pi, math.Atan2(y, x) * 180 / math.Pi
+}
+
+// Converts lat and lon degrees to 3D Cartesian coordinates on a unit sphere.
+func geoCoords(lat, lon float64) ([]float64, error) {
+	if math.IsNaN(lat) || lat < -90 || lat > 90 {
+		return nil, errors.New("Latitude must be between -90 and 90 degrees.")
+	}
+	if math.IsNaN(lon) || math.IsInf(lon, 0) {
+		return nil, errors.New("Longitude must be a finite number of degrees.")
+	}
+	phi, lambda := lat*math.Pi/180, lon*math.Pi/180
+	return []float64{math.Cos(phi) * math.Cos(lambda), math.Cos(phi) * math.Sin(lambda), math.Sin(phi)}, nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2012 by Graeme Humphries <graeme@sudo.ca>
+//
+// kdtree is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// kdtree is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with kdtree.  If not, see http://www.gnu.org/licenses/.
+
+package kdtree
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// Great circle distance in kilometers between two points, by the haversine formula.
+func haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	phi1, phi2 := lat1*math.Pi/180, lat2*math.Pi/180
+	dphi, dlambda := phi2-phi1, (lon2-lon1)*math.Pi/180
+	a := math.Sin(dphi/2)*math.Sin(dphi/2) + math.Cos(phi1)*math.Cos(phi2)*math.Sin(dlambda/2)*math.Sin(dlambda/2)
+	return 2 * math.Asin(math.Sqrt(a)) * EarthRadiusKm
+}
+
+// GeoTrees should search by great circle distance, whatever DefaultMetric is.
+func TestGeoTree(t *testing.T) {
+	defer func(m Metric) { DefaultMetric = m }(DefaultMetric)
+	DefaultMetric = ManhattanMetric{}
+	geo := NewGeoTree()
+	points := make([][2]float64, 2000)
+	for i := range points {
+		points[i] = [2]float64{rand.Float64()*180 - 90, rand.Float64()*360 - 180}
+		if _, err := geo.Add(points[i][0], points[i][1], i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 200; i++ {
+		lat, lon := rand.Float64()*180-90, rand.Float64()*360-180
+		n, dist, err := geo.NearestByGreatCircle(lat, lon)
+		if err != nil {
+			t.Fatal("Error while searching tree:", err)
+		}
+		best, bestDist := 0, math.Inf(1)
+		for j, p := range points {
+			if d := haversine(lat, lon, p[0], p[1]); d < bestDist {
+				best, bestDist = j, d
+			}
+		}
+		if n.Value.(int) != best || math.Abs(dist-bestDist) > 1e-6 {
+			t.Fatal("Nearest to", lat, lon, "should be point", best, "at", bestDist, "km, found", n.Value, "at", dist, "km")
+		}
+		if nlat, nlon := LatLon(n); math.Abs(nlat-points[best][0]) > 1e-9 || math.Abs(nlon-points[best][1]) > 1e-9 {
+			t.Fatal("Point", best, "converted back to", nlat, nlon, "expected", points[best])
+		}
+	}
+
+	// points either side of the antimeridian are close together
+	across := NewGeoTree()
+	across.Add(0, 179.9, "east")
+	across.Add(0, 170, "west")
+	if n, dist, _ := across.NearestByGreatCircle(0, -179.9); n.Value != "east" || math.Abs(dist-haversine(0, -179.9, 0, 179.9)) > 1e-6 {
+		t.Fatal("Nearest across the antimeridian should be east, found", n.Value, "at", dist, "km")
+	}
+
+	if _, _, err := geo.NearestByGreatCircle(91, 0); err == nil {
+		t.Fatal("Searching with a latitude outside of [-90, 90] should return an error.")
+	}
+	if n, dist, err := NewGeoTree().NearestByGreatCircle(0, 0); n != nil || dist != 0 || err != nil {
+		t.Fatal("Searching an empty tree should return (nil, 0, nil).")
+	}
+}
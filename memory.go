@@ -0,0 +1,62 @@
+// Copyright 2012 by Graeme Humphries <graeme@sudo.ca>
+//
+// kdtree is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// kdtree is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with kdtree.  If not, see http://www.gnu.org/licenses/.
+
+package kdtree
+
+import (
+	"unsafe"
+)
+
+/***** Memory Estimates *****/
+
+// Sizes in bytes of the values making up Trees and FlatTrees.
+const (
+	nodeBytes    = int(unsafe.Sizeof(Node{}))
+	pointerBytes = int(unsafe.Sizeof((*Node)(nil)))
+	float64Bytes = 8
+	int32Bytes   = 4
+)
+
+// Returns the approximate number of bytes of heap a Tree of nodeCount nodes, each with its own
+// coordinates of the given dimensions, takes up. This counts each Node and its coordinates, but not
+// anything referred to by its Value, nor the allocator's rounding up of each allocation.
+func EstimateMemory(nodeCount, dimensions int) int {
+	return int(unsafe.Sizeof(Tree{})) + nodeCount*(nodeBytes+dimensions*float64Bytes)
+}
+
+// Returns the approximate number of bytes of heap a FlatTree frozen from a Tree of nodeCount nodes
+// with the given dimensions takes up, on top of the Tree's own nodes, which the FlatTree shares.
+func EstimateFlatMemory(nodeCount, dimensions int) int {
+	return int(unsafe.Sizeof(FlatTree{})) + nodeCount*(dimensions*float64Bytes+4*int32Bytes+pointerBytes)
+}
+
+// Returns the approximate number of bytes of heap the Tree takes up, counted in the same way as
+// EstimateMemory, but from the actual capacity of each node's coordinates and bucket.
+func (t *Tree) MemoryBytes() int {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	total := int(unsafe.Sizeof(*t))
+	t.Root.traverse(func(n *Node) {
+		total += nodeBytes + cap(n.Coordinates)*float64Bytes + cap(n.bucket)*pointerBytes
+	})
+	return total
+}
+
+// Returns the approximate number of bytes of heap the FlatTree's arrays take up, counted in the
+// same way as EstimateFlatMemory. This doesn't include the Tree's nodes, which the FlatTree shares.
+func (f *FlatTree) MemoryBytes() int {
+	return int(unsafe.Sizeof(*f)) + cap(f.coordinates)*float64Bytes +
+		(cap(f.axes)+cap(f.left)+cap(f.right)+cap(f.bucket))*int32Bytes + cap(f.nodes)*pointerBytes
+}
@@ -0,0 +1,45 @@
+// Copyright 2012 by Graeme Humphries <graeme@sudo.ca>
+//
+// kdtree is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// kdtree is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with kdtree.  If not, see http://www.gnu.org/licenses/.
+
+package kdtree
+
+import (
+	"testing"
+)
+
+// Trees of nodes with exactly sized coordinates should match the estimates for their size, which
+// should grow with both the number of nodes and their dimensions.
+func TestMemoryBytes(t *testing.T) {
+	tree := BuildTree(genlist(6, 1000))
+	if actual, estimate := tree.MemoryBytes(), EstimateMemory(1000, 6); actual != estimate {
+		t.Fatal("Tree takes up", actual, "bytes, estimated", estimate)
+	}
+	if actual, estimate := tree.Freeze().MemoryBytes(), EstimateFlatMemory(1000, 6); actual != estimate {
+		t.Fatal("FlatTree takes up", actual, "bytes, estimated", estimate)
+	}
+	if empty := new(Tree).MemoryBytes(); empty != EstimateMemory(0, 6) {
+		t.Fatal("Empty tree takes up", empty, "bytes, estimated", EstimateMemory(0, 6))
+	}
+
+	if EstimateMemory(2000, 6) <= EstimateMemory(1000, 6) || EstimateMemory(1000, 12) <= EstimateMemory(1000, 6) {
+		t.Fatal("EstimateMemory doesn't grow with the number of nodes and dimensions.")
+	}
+	if EstimateFlatMemory(1000, 6) >= EstimateMemory(1000, 6) {
+		t.Fatal("A FlatTree should take up less than the nodes it shares with its Tree.")
+	}
+	if bucket := BuildTreeBucket(genlist(6, 1000), 8).MemoryBytes(); bucket <= EstimateMemory(1000, 6) {
+		t.Fatal("Bucket tree takes up", bucket, "bytes, which should include its buckets.")
+	}
+}
@@ -0,0 +1,412 @@
+// Copyright 2012 by Graeme Humphries <graeme@sudo.ca>
+//
+// kdtree is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// kdtree is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with kdtree.  If not, see http://www.gnu.org/licenses/.
+
+package kdtree
+
+import (
+	"math"
+)
+
+/***** Distance Metrics *****/
+
+// Metric measures distances between points for the nearest neighbor and radius searches.
+// Tree uses DefaultMetric when its Metric field is nil.
+type Metric interface {
+	// Returns the distance between two points of equal dimensions.
+	Distance(a, b []float64) float64
+
+	// Returns the distance between two points that differ only on axis, where a and b are
+	// their coordinates on that axis. Searches use this as the distance from a query point to
+	// a splitting plane, so it must never be greater than Distance from a point with coordinate
+	// a on axis to any point on the other side of b, or subtrees containing matches may be skipped.
+	AxisDistance(a, b float64, axis int) float64
+}
+
+// Straight-line (L2) distance.
+type EuclideanMetric struct{}
+
+func (EuclideanMetric) Distance(a, b []float64) float64 {
+	sum := 0.0
+	for i := 0; i < len(a); i++ {
+		d := a[i] - b[i]
+		sum += d * d
+	}
+	return math.Sqrt(sum)
+}
+
+func (EuclideanMetric) AxisDistance(a, b float64, axis int) float64 {
+	return math.Abs(a - b)
+}
+
+func (EuclideanMetric) reducedDistance(a, b []float64) float64 {
+	sum := 0.0
+	for i := 0; i < len(a); i++ {
+		d := a[i] - b[i]
+		sum += d * d
+	}
+	return sum
+}
+
+func (EuclideanMetric) reducedAxisDistance(a, b float64, axis int) float64 {
+	return (a - b) * (a - b)
+}
+
+func (EuclideanMetric) reducedPower() float64 {
+	return 2
+}
+
+// Taxicab (L1) distance, the sum of distances along each axis.
+type ManhattanMetric struct{}
+
+func (ManhattanMetric) Distance(a, b []float64) float64 {
+	sum := 0.0
+	for i := 0; i < len(a); i++ {
+		sum += math.Abs(a[i] - b[i])
+	}
+	return sum
+}
+
+func (ManhattanMetric) AxisDistance(a, b float64, axis int) float64 {
+	return math.Abs(a - b)
+}
+
+// Chebyshev (L-infinity) distance, the greatest distance along any single axis.
+type ChebyshevMetric struct{}
+
+func (ChebyshevMetric) Distance(a, b []float64) float64 {
+	max := 0.0
+	for i := 0; i < len(a); i++ {
+		if d := math.Abs(a[i] - b[i]); d > max {
+			max = d
+		}
+	}
+	return max
+}
+
+func (ChebyshevMetric) AxisDistance(a, b float64, axis int) float64 {
+	return math.Abs(a - b)
+}
+
+// Minkowski (Lp) distance, the P-th root of the sum of distances along each axis raised to the
+// power P. P == 1 is the same as ManhattanMetric, and P == 2 is the same as EuclideanMetric.
+// P should be >= 1, as smaller values don't satisfy the triangle inequality.
+// Searches compare distances raised to the power P, so they never need to take P-th roots.
+type MinkowskiMetric struct {
+	P float64
+}
+
+func (m MinkowskiMetric) Distance(a, b []float64) float64 {
+	switch m.P {
+	case 1:
+		return ManhattanMetric{}.Distance(a, b)
+	case 2:
+		return EuclideanMetric{}.Distance(a, b)
+	}
+	return math.Pow(m.reducedDistance(a, b), 1/m.P)
+}
+
+func (m MinkowskiMetric) AxisDistance(a, b float64, axis int) float64 {
+	return math.Abs(a - b)
+}
+
+func (m MinkowskiMetric) reducedDistance(a, b []float64) float64 {
+	switch m.P {
+	case 1:
+		return ManhattanMetric{}.Distance(a, b)
+	case 2:
+		return EuclideanMetric{}.reducedDistance(a, b)
+	}
+	sum := 0.0
+	for i := 0; i < len(a); i++ {
+		sum += math.Pow(math.Abs(a[i]-b[i]), m.P)
+	}
+	return sum
+}
+
+func (m MinkowskiMetric) reducedAxisDistance(a, b float64, axis int) float64 {
+	switch m.P {
+	case 1:
+		return math.Abs(a - b)
+	case 2:
+		return (a - b) * (a - b)
+	}
+	return math.Pow(math.Abs(a-b), m.P)
+}
+
+func (m MinkowskiMetric) reducedPower() float64 {
+	return m.P
+}
+
+// Euclidean distance on a periodic domain, where axis i wraps around at Period[i], so coordinates
+// 0 and Period[i] are the same point. The distance along a wrapped axis is min(|a-b|, Period[i]-|a-b|).
+// Axes with no Period, or a Period <= 0, don't wrap. Coordinates on wrapped axes must be in
+// [0, Period[i]) for searches to be correct.
+type WrappedMetric struct {
+	Period []float64
+}
+
+// Returns the period of axis, or 0 if it doesn't wrap.
+func (m WrappedMetric) period(axis int) float64 {
+	if axis < len(m.Period) && m.Period[axis] > 0 {
+		return m.Period[axis]
+	}
+	return 0
+}
+
+// Returns the distance between a and b along axis, going whichever way around is shorter.
+func (m WrappedMetric) axisDiff(a, b float64, axis int) float64 {
+	d := math.Abs(a - b)
+	if p := m.period(axis); p > 0 {
+		d = math.Mod(d, p)
+		if p-d < d {
+			d = p - d
+		}
+	}
+	return d
+}
+
+func (m WrappedMetric) Distance(a, b []float64) float64 {
+	return math.Sqrt(m.reducedDistance(a, b))
+}
+
+// Points on the other side of a splitting plane at b can be reached either across the plane, or
+// the other way around the axis, through the edge of the domain behind a. The nearest such point
+// is as close as the plane or that edge, whichever is closer, so a search near an edge also checks
+// subtrees near the opposite edge.
+func (m WrappedMetric) AxisDistance(a, b float64, axis int) float64 {
+	d := math.Abs(a - b)
+	if p := m.period(axis); p > 0 {
+		edge := p - a
+		if a < b {
+			edge = a
+		}
+		if edge < d {
+			d = math.Max(edge, 0)
+		}
+	}
+	return d
+}
+
+func (m WrappedMetric) reducedDistance(a, b []float64) float64 {
+	sum := 0.0
+	for i := 0; i < len(a); i++ {
+		d := m.axisDiff(a[i], b[i], i)
+		sum += d * d
+	}
+	return sum
+}
+
+func (m WrappedMetric) reducedAxisDistance(a, b float64, axis int) float64 {
+	d := m.AxisDistance(a, b, axis)
+	return d * d
+}
+
+func (m WrappedMetric) reducedPower() float64 {
+	return 2
+}
+
+// Euclidean distance with the difference along axis i multiplied by Weights[i] before squaring,
+// so axes with larger weights count for more without rescaling the stored coordinates.
+// Axes with no weight have a weight of 1.
+type WeightedMetric struct {
+	Weights []float64
+}
+
+// Returns the weight of axis.
+func (m WeightedMetric) weight(axis int) float64 {
+	if axis < len(m.Weights) {
+		return m.Weights[axis]
+	}
+	return 1
+}
+
+func (m WeightedMetric) Distance(a, b []float64) float64 {
+	return math.Sqrt(m.reducedDistance(a, b))
+}
+
+// The distance across a splitting plane is weighted in the same way as differences along its axis.
+func (m WeightedMetric) AxisDistance(a, b float64, axis int) float64 {
+	return math.Abs(m.weight(axis) * (a - b))
+}
+
+func (m WeightedMetric) reducedDistance(a, b []float64) float64 {
+	sum := 0.0
+	for i := 0; i < len(a); i++ {
+		d := m.weight(i) * (a[i] - b[i])
+		sum += d * d
+	}
+	return sum
+}
+
+func (m WeightedMetric) reducedAxisDistance(a, b float64, axis int) float64 {
+	d := m.weight(axis) * (a - b)
+	return d * d
+}
+
+func (m WeightedMetric) reducedPower() float64 {
+	return 2
+}
+
+// Metric used by every Tree whose Metric field is nil, including trees built by BuildTree and
+// LoadCSV. Trees with their own Metric aren't affected by it. It's looked up each time such a tree is
+// searched, not copied when the tree is made, so changing it changes how existing trees search. It's
+// read without locking, so it should only be changed before any trees are searched. EuclideanMetric
+// is used if it's nil.
+var DefaultMetric Metric = EuclideanMetric{}
+
+// Returns the Metric used by Tree searches.
+func (t *Tree) metric() Metric {
+	return orDefault(t.Metric)
+}
+
+// Returns m, or DefaultMetric if m is nil, or EuclideanMetric if both are nil.
+func orDefault(m Metric) Metric {
+	if m != nil {
+		return m
+	}
+	if DefaultMetric != nil {
+		return DefaultMetric
+	}
+	return EuclideanMetric{}
+}
+
+// Tests whether distances measured by m grow with the difference between two points on every axis,
+// so the nearest and farthest points of a box from a point can be found one axis at a time. This is
+// true of all of this package's Metrics except WrappedMetric.
+func growsOnEveryAxis(m Metric) bool {
+	switch m.(type) {
+	case EuclideanMetric, ManhattanMetric, ChebyshevMetric, MinkowskiMetric, WeightedMetric:
+		return true
+	}
+	return false
+}
+
+// Returns a lower bound on the distance, measured by metric, from point to any point in box, where
+// box[a] is the box's extent on axis a, or 0 if point is in the box. A nil metric uses DefaultMetric.
+// For Metrics that grow with the difference on every axis, as all of this package's Metrics except
+// WrappedMetric do, this is the exact distance to the nearest point of the box. For any other Metric
+// it's the greatest AxisDistance from point to a face of the box it's outside of, which the Metric
+// interface guarantees no point in the box is closer than, just as searches bound splitting planes.
+// Panics with ErrDimensionMismatch if box doesn't have one Range for each axis of point.
+func MinDistToBox(point []float64, box []Range, metric Metric) float64 {
+	if len(box) != len(point) {
+		panic(ErrDimensionMismatch)
+	}
+	metric = orDefault(metric)
+	if growsOnEveryAxis(metric) {
+		nearest := make([]float64, len(point))
+		for a, c := range point {
+			nearest[a] = math.Max(box[a].Min, math.Min(c, box[a].Max))
+		}
+		return metric.Distance(point, nearest)
+	}
+
+	bound := 0.0
+	for a, c := range point {
+		if c < box[a].Min {
+			bound = math.Max(bound, metric.AxisDistance(c, box[a].Min, a))
+		} else if c > box[a].Max {
+			bound = math.Max(bound, metric.AxisDistance(c, box[a].Max, a))
+		}
+	}
+	return bound
+}
+
+// Returns an upper bound on the distance, measured by metric, from point to any point in box, where
+// box[a] is the box's extent on axis a. A nil metric uses DefaultMetric. For Metrics that grow with the
+// difference on every axis, as all of this package's Metrics except WrappedMetric do, this is the exact
+// distance to the farthest corner of the box. The Metric interface gives no upper bound for any other
+// Metric, so +Inf is returned.
+// Panics with ErrDimensionMismatch if box doesn't have one Range for each axis of point.
+func MaxDistToBox(point []float64, box []Range, metric Metric) float64 {
+	if len(box) != len(point) {
+		panic(ErrDimensionMismatch)
+	}
+	metric = orDefault(metric)
+	if !growsOnEveryAxis(metric) {
+		return math.Inf(1)
+	}
+	corner := make([]float64, len(point))
+	for a, c := range point {
+		if c-box[a].Min > box[a].Max-c {
+			corner[a] = box[a].Min
+		} else {
+			corner[a] = box[a].Max
+		}
+	}
+	return metric.Distance(point, corner)
+}
+
+// Metric which can compare distances as Distance^p, which is cheaper to compute than Distance
+// itself. For example, EuclideanMetric can compare squared distances without taking square roots.
+type reducedMetric interface {
+	Metric
+	reducedDistance(a, b []float64) float64
+	reducedAxisDistance(a, b float64, axis int) float64
+	reducedPower() float64 // p
+}
+
+// Metric used by searches, which compares reduced distances when the Metric supports them,
+// and only converts the distances it returns back to real distances.
+type searchMetric struct {
+	Metric
+	reduced reducedMetric // nil if Metric doesn't support reduced distances
+	power   float64       // reduced distances are Distance^power
+}
+
+func newSearchMetric(m Metric) searchMetric {
+	if r, ok := m.(reducedMetric); ok {
+		return searchMetric{m, r, r.reducedPower()}
+	}
+	return searchMetric{m, nil, 1}
+}
+
+// Returns the reduced distance between two points of equal dimensions.
+func (m searchMetric) distance(a, b []float64) float64 {
+	if m.reduced == nil {
+		return m.Distance(a, b)
+	}
+	return m.reduced.reducedDistance(a, b)
+}
+
+// Returns the reduced distance from a to a splitting plane at b on axis.
+func (m searchMetric) axisDistance(a, b float64, axis int) float64 {
+	if m.reduced == nil {
+		return m.AxisDistance(a, b, axis)
+	}
+	return m.reduced.reducedAxisDistance(a, b, axis)
+}
+
+// Converts a distance to a reduced distance.
+func (m searchMetric) toReduced(d float64) float64 {
+	switch m.power {
+	case 1:
+		return d
+	case 2:
+		return d * d
+	}
+	return math.Pow(d, m.power)
+}
+
+// Converts a reduced distance to a distance.
+func (m searchMetric) fromReduced(d float64) float64 {
+	switch m.power {
+	case 1:
+		return d
+	case 2:
+		return math.Sqrt(d)
+	}
+	return math.Pow(d, 1/m.power)
+}
@@ -0,0 +1,841 @@
+// Copyright 2012 by Graeme Humphries <graeme@sudo.ca>
+//
+// kdtree is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// kdtree is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with kdtree.  If not, see http://www.gnu.org/licenses/.
+
+package kdtree
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"math"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+/***** Nearest Neighbor Search Functions *****/
+
+// Trees with more dimensions than this are searched for nearest neighbors by scanning every node,
+// unless their LinearScanDimensions is set. In many dimensions the nearest node is usually about as
+// far away as most of the tree, so splitting planes are nearly always closer and almost nothing is
+// pruned; a scan then finds the same nodes without deciding which side of each split to search first,
+// or checking the distance to every plane.
+var DefaultLinearScanDimensions = 16
+
+// Searches Tree for the node closest to coords, using the Tree's Metric. Returns the node and
+// its distance from coords, (nil, 0, nil) if the tree is empty, or (nil, 0, error) if
+// len(coords) != tree dimensions.
+func (t *Tree) NearestNeighbor(coords []float64) (*Node, float64, error) {
+	return t.NearestMatching(coords, nil)
+}
+
+// Searches Tree for the node closest to coords for which pred returns true, using the Tree's
+// Metric. A nil pred matches every node. Returns the node and its distance from coords,
+// (nil, 0, nil) if no node matches, or (nil, 0, error) if len(coords) != tree dimensions.
+func (t *Tree) NearestMatching(coords []float64, pred func(*Node) bool) (*Node, float64, error) {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	if t.Root == nil {
+		return nil, 0, nil
+	}
+	if err := t.Root.checkDimensions(coords); err != nil {
+		return nil, 0, err
+	}
+
+	s := newNearestSearch(coords, t.metric())
+	s.match = pred
+	t.searchNearest(s)
+	if s.best == nil {
+		return nil, 0, nil
+	}
+	t.touch(s.best)
+	return s.best, s.metric.fromReduced(s.bestDist), nil
+}
+
+// Performs the same search as NearestNeighbor, also returning every node whose distance from coords
+// was measured, in the order they were examined, for debugging and visualizing the search. Comparing
+// the number of nodes examined with the Tree's Size shows how much of the tree was pruned. The tree
+// is always searched, even where NearestNeighbor would scan it, as set by LinearScanDimensions.
+// Returns (nil, 0, nil, nil) if the tree is empty, or (nil, 0, nil, error) if len(coords) != tree
+// dimensions.
+func (t *Tree) NearestNeighborTrace(coords []float64) (*Node, float64, []*Node, error) {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	if t.Root == nil {
+		return nil, 0, nil, nil
+	}
+	if err := t.Root.checkDimensions(coords); err != nil {
+		return nil, 0, nil, err
+	}
+
+	var trace []*Node
+	s := newNearestSearch(coords, t.metric())
+	s.visit = func(n *Node) {
+		trace = append(trace, n)
+	}
+	s.search(t.Root)
+	return s.best, s.metric.fromReduced(s.bestDist), trace, nil
+}
+
+// Searches Tree for the node closest to n, other than n itself, using the Tree's Metric. Other
+// nodes at the same coordinates as n are still found, at distance 0. Returns the node and its
+// distance from n, (nil, 0, nil) if there is no other node in the tree, or (nil, 0, error) if n is
+// nil or doesn't have the same dimensions as the tree.
+func (t *Tree) NearestExcluding(n *Node) (*Node, float64, error) {
+	if n == nil {
+		return nil, 0, errors.New("Can't search for the nearest neighbor of a nil node.")
+	}
+	return t.NearestMatching(n.Coordinates, func(o *Node) bool {
+		return o != n
+	})
+}
+
+// Performs the same search as NearestNeighbor, but returns the squared distance to the nearest
+// node. For EuclideanMetric this saves taking a square root, as searches compare squared distances.
+func (t *Tree) NearestNeighborSquared(coords []float64) (*Node, float64, error) {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	if t.Root == nil {
+		return nil, 0, nil
+	}
+	if err := t.Root.checkDimensions(coords); err != nil {
+		return nil, 0, err
+	}
+
+	s := newNearestSearch(coords, t.metric())
+	t.searchNearest(s)
+	if s.metric.power == 2 {
+		return s.best, s.bestDist, nil
+	}
+	d := s.metric.fromReduced(s.bestDist)
+	return s.best, d * d, nil
+}
+
+// Searches Tree for a node approximately closest to coords, using the Tree's Metric, by skipping
+// the far side of any splitting plane more than best/(1+epsilon) away, where best is the distance
+// to the closest node found so far. The returned node is at most (1+epsilon) times as far from
+// coords as the true nearest neighbor, and with epsilon == 0 the result is the same as NearestNeighbor.
+// Returns (nil, 0, nil) if the tree is empty, or (nil, 0, error) if len(coords) != tree dimensions
+// or epsilon is negative.
+func (t *Tree) ApproxNearest(coords []float64, epsilon float64) (*Node, float64, error) {
+	if epsilon < 0 {
+		return nil, 0, errors.New("Approximation epsilon can't be negative.")
+	}
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	if t.Root == nil {
+		return nil, 0, nil
+	}
+	if err := t.Root.checkDimensions(coords); err != nil {
+		return nil, 0, err
+	}
+
+	s := newNearestSearch(coords, t.metric())
+	s.shrink = s.metric.toReduced(1 + epsilon)
+	t.searchNearest(s)
+	return s.best, s.metric.fromReduced(s.bestDist), nil
+}
+
+// Searches Tree for the node closest to each of queries, as NearestNeighbor would, returning a
+// list of nodes where result[i] is nearest to queries[i]. The Tree is read locked once for the
+// whole batch, and queries are split between workers goroutines, or runtime.GOMAXPROCS(0)
+// goroutines if workers < 1. The Tree's Metric must be safe to use from multiple goroutines.
+// Returns (nil, error) if any query doesn't have the same dimensions as the tree.
+func (t *Tree) NearestBatch(queries [][]float64, workers int) ([]*Node, error) {
+	neighbors, err := t.NearestBatchContext(context.Background(), queries, workers)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*Node, len(neighbors))
+	for i, nb := range neighbors {
+		result[i] = nb.Node
+	}
+	return result, nil
+}
+
+// Performs the same searches as NearestBatch, returning each nearest node with its distance, where
+// result[i] is nearest to queries[i], or a Neighbor with a nil Node if the tree is empty. No more than
+// workers goroutines search at once. ctx is checked between queries, and if it's cancelled before
+// every query has been searched, (nil, ctx.Err()) is returned rather than partial results.
+// Returns (nil, error) if any query doesn't have the same dimensions as the tree.
+func (t *Tree) NearestBatchContext(ctx context.Context, queries [][]float64, workers int) ([]Neighbor, error) {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	result := make([]Neighbor, len(queries))
+	if t.Root == nil {
+		return result, nil
+	}
+	for _, coords := range queries {
+		if err := t.Root.checkDimensions(coords); err != nil {
+			return nil, err
+		}
+	}
+
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	indexes := make(chan int, workers)
+	var wg sync.WaitGroup
+	var skipped int32 // set if any worker skipped a query after ctx was cancelled
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if ctx.Err() != nil {
+					atomic.StoreInt32(&skipped, 1)
+					continue
+				}
+				s := newNearestSearch(queries[i], t.metric())
+				t.searchNearest(s)
+				result[i] = Neighbor{s.best, s.metric.fromReduced(s.bestDist)}
+			}
+		}()
+	}
+	stopped := false
+send:
+	for i := range queries {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			stopped = true
+			break send
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	// only fail if some query went unsearched, not if ctx was cancelled after they all finished
+	if stopped || atomic.LoadInt32(&skipped) != 0 {
+		return nil, ctx.Err()
+	}
+	return result, nil
+}
+
+// Finds the nearest node in other to every node in the Tree, using other's Metric, returning a map
+// from each of the Tree's nodes to its nearest neighbor in other. The Tree's nodes are searched for
+// in traversal order, so consecutive ones are close together, and each search starts from the
+// previous node's neighbor as its best match so far, which lets it skip most of other straight away.
+// The results are exact. Both trees are read locked for the whole join. A Tree joined with itself
+// maps each node to itself, or to another node at the same coordinates.
+// Returns an empty map if either tree is empty, or (nil, ErrDimensionMismatch) if the trees have
+// different dimensions.
+func (t *Tree) NearestJoin(other *Tree) (map[*Node]*Node, error) {
+	first, second := lockOrder(t, other)
+	first.Mutex.RLock()
+	defer first.Mutex.RUnlock()
+	if second != first {
+		second.Mutex.RLock()
+		defer second.Mutex.RUnlock()
+	}
+
+	result := make(map[*Node]*Node, t.count)
+	if t.Root == nil || other.Root == nil {
+		return result, nil
+	}
+	if len(t.Root.Coordinates) != len(other.Root.Coordinates) {
+		return nil, ErrDimensionMismatch
+	}
+
+	m := other.metric()
+	var previous *Node
+	t.Root.traverse(func(n *Node) {
+		s := newNearestSearch(n.Coordinates, m)
+		if previous != nil {
+			s.best, s.bestDist = previous, s.metric.distance(n.Coordinates, previous.Coordinates)
+		}
+		s.search(other.Root)
+		result[n] = s.best
+		previous = s.best
+	})
+	return result, nil
+}
+
+// State of a search for the single node nearest to coords.
+type nearestSearch struct {
+	coords []float64
+	metric searchMetric
+	match  func(*Node) bool // nodes must match to be returned, nil matches everything
+	visit  func(*Node)      // called with every node checked, for NearestNeighborTrace, or nil
+
+	// splitting planes must be closer than bestDist/shrink to be crossed
+	shrink float64
+
+	// closest matching node found so far, and its reduced distance from coords
+	best     *Node
+	bestDist float64
+}
+
+// Tests whether the Tree, which mustn't be empty, has too many dimensions to be worth searching
+// rather than scanning, by its LinearScanDimensions.
+func (t *Tree) linearScan() bool {
+	limit := t.LinearScanDimensions
+	if limit == 0 {
+		limit = DefaultLinearScanDimensions
+	}
+	return limit > 0 && len(t.Root.Coordinates) > limit
+}
+
+// Runs search s over the Tree, which mustn't be empty, or over every node if linearScan is true.
+// A scan finds the same best match, though not always the same one of several equally close nodes.
+func (t *Tree) searchNearest(s *nearestSearch) {
+	if t.linearScan() {
+		t.Root.traverse(s.check)
+	} else {
+		s.search(t.Root)
+	}
+}
+
+// Returns a search for the node nearest to coords using metric m, which matches every node.
+func newNearestSearch(coords []float64, m Metric) *nearestSearch {
+	return &nearestSearch{coords: coords, metric: newSearchMetric(m), shrink: 1, bestDist: math.Inf(1)}
+}
+
+// Searches (sub)tree for a matching node closer to the query coordinates than the best so far.
+//
+// The search descends towards the leaf the coordinates would be inserted at, then on the way back
+// up only checks the far side of a splitting plane if the plane is closer than the best match.
+// Nodes that don't match never become the best, so pruning only uses distances of matching nodes.
+func (s *nearestSearch) search(n *Node) {
+	if n == nil {
+		return
+	}
+
+	near, far := n.leftChild, n.rightChild
+	if s.coords[n.axis] >= n.Coordinates[n.axis] {
+		near, far = far, near
+	}
+	s.search(near)
+
+	s.check(n)
+	for _, b := range n.bucket {
+		s.check(b)
+	}
+	// only cross the splitting plane if it's closer than the best match so far
+	if s.metric.axisDistance(s.coords[n.axis], n.Coordinates[n.axis], n.axis) < s.bestDist/s.shrink {
+		s.search(far)
+	}
+}
+
+// Makes node n the best match if it matches and is closer than the best so far.
+func (s *nearestSearch) check(n *Node) {
+	if s.visit != nil {
+		s.visit(n)
+	}
+	if d := s.metric.distance(s.coords, n.Coordinates); d < s.bestDist && (s.match == nil || s.match(n)) {
+		s.best, s.bestDist = n, d
+	}
+}
+
+// Searches Tree for the k nodes closest to coords, using the Tree's Metric. Returns the nodes
+// sorted by ascending distance from coords, or all nodes in the tree if there are fewer than k.
+// The order is guaranteed, so the first node is always a nearest neighbor, but nodes at equal
+// distances may be in any order.
+// Returns (nil, nil) if the tree is empty, or (nil, error) if len(coords) != tree dimensions.
+// Searches only hold the read lock, and keep their state in their own heap rather than on nodes,
+// so any number of them can run at once.
+func (t *Tree) KNearest(coords []float64, k int) ([]*Node, error) {
+	return neighborNodes(t.KNearestWithDistance(coords, k))
+}
+
+// Returns the nodes from a list of neighbors, or (nil, err) if there are none.
+func neighborNodes(neighbors []Neighbor, err error) ([]*Node, error) {
+	if neighbors == nil {
+		return nil, err
+	}
+	result := make([]*Node, len(neighbors))
+	for i, nb := range neighbors {
+		result[i] = nb.Node
+	}
+	return result, nil
+}
+
+// A node found by a nearest neighbor search, and its distance from the search coordinates.
+type Neighbor struct {
+	Node     *Node
+	Distance float64
+}
+
+// Performs the same search as KNearest, but returns each node with its distance from coords,
+// measured with the Tree's Metric. The Neighbors are sorted by ascending distance.
+func (t *Tree) KNearestWithDistance(coords []float64, k int) ([]Neighbor, error) {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	return kNearestNeighbors(t.Root, t.metric(), coords, k, t.Root != nil && t.linearScan())
+}
+
+// Returns every node in the Tree with its distance from coords, measured with the Tree's Metric,
+// sorted by ascending distance, for listing results nearest first with no limit. Every node is
+// measured and sorted, taking O(n log n) time, so KNearest, which only visits the part of the tree
+// near coords, is far cheaper when only the first few nodes are needed, and NearestIterator when
+// it's not known in advance how many will be.
+// Returns (nil, nil) if the tree is empty, or (nil, error) if len(coords) != tree dimensions.
+func (t *Tree) AllByDistance(coords []float64) ([]Neighbor, error) {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	if t.Root == nil {
+		return nil, nil
+	}
+	if err := t.Root.checkDimensions(coords); err != nil {
+		return nil, err
+	}
+
+	m := newSearchMetric(t.metric())
+	h := make(neighborHeap, 0, t.count)
+	t.Root.traverse(func(n *Node) {
+		h.offer(n, m.distance(coords, n.Coordinates), 0)
+	})
+	return h.sorted(m), nil
+}
+
+// Searches the tree at root for the k nodes closest to coords using metric, for KNearestWithDistance,
+// or checks every node if scan is true.
+func kNearestNeighbors(root *Node, metric Metric, coords []float64, k int, scan bool) ([]Neighbor, error) {
+	if root == nil || k <= 0 {
+		return nil, nil
+	}
+	if err := root.checkDimensions(coords); err != nil {
+		return nil, err
+	}
+
+	m := newSearchMetric(metric)
+	h := make(neighborHeap, 0, k)
+	if scan {
+		root.traverse(func(n *Node) {
+			h.offer(n, m.distance(coords, n.Coordinates), k)
+		})
+	} else {
+		root.kNearest(coords, m, k, &h)
+	}
+	return h.sorted(m), nil
+}
+
+// Searches (sub)tree for the k nodes closest to coords, keeping the best candidates found so
+// far in h, with reduced distances. Subtrees are pruned once h holds k nodes and the splitting
+// plane is farther away than the k-th best candidate.
+func (n *Node) kNearest(coords []float64, m searchMetric, k int, h *neighborHeap) {
+	if n == nil {
+		return
+	}
+
+	near, far := n.leftChild, n.rightChild
+	if coords[n.axis] >= n.Coordinates[n.axis] {
+		near, far = far, near
+	}
+	near.kNearest(coords, m, k, h)
+
+	h.offer(n, m.distance(coords, n.Coordinates), k)
+	for _, b := range n.bucket {
+		h.offer(b, m.distance(coords, b.Coordinates), k)
+	}
+	if h.Len() < k || m.axisDistance(coords[n.axis], n.Coordinates[n.axis], n.axis) < (*h)[0].Distance {
+		far.kNearest(coords, m, k, h)
+	}
+}
+
+// Searches Tree for up to maxResults nodes within radius of coords, using the Tree's Metric. Returns
+// the closest ones sorted by ascending distance, so once maxResults nodes have been found, subtrees
+// farther away than the farthest of them are skipped. If maxResults <= 0, every node within radius is
+// returned, as FindWithinRadius would, but sorted. Returns (nil, nil) if no nodes are within radius,
+// or (nil, error) if len(coords) != tree dimensions.
+func (t *Tree) SearchAround(coords []float64, radius float64, maxResults int) ([]Neighbor, error) {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	if t.Root == nil || radius < 0 {
+		return nil, nil
+	}
+	if err := t.Root.checkDimensions(coords); err != nil {
+		return nil, err
+	}
+
+	m := newSearchMetric(t.metric())
+	h := make(neighborHeap, 0)
+	t.Root.searchAround(coords, m, maxResults, m.toReduced(radius), &h)
+	if h.Len() == 0 {
+		return nil, nil
+	}
+	return h.sorted(m), nil
+}
+
+// Searches (sub)tree for up to k nodes within reduced distance radius of coords, or every node
+// within radius if k <= 0, keeping them in h in the same way as kNearest.
+func (n *Node) searchAround(coords []float64, m searchMetric, k int, radius float64, h *neighborHeap) {
+	if n == nil {
+		return
+	}
+
+	near, far := n.leftChild, n.rightChild
+	if coords[n.axis] >= n.Coordinates[n.axis] {
+		near, far = far, near
+	}
+	near.searchAround(coords, m, k, radius, h)
+
+	if d := m.distance(coords, n.Coordinates); d <= radius {
+		h.offer(n, d, k)
+	}
+	for _, b := range n.bucket {
+		if d := m.distance(coords, b.Coordinates); d <= radius {
+			h.offer(b, d, k)
+		}
+	}
+	// the plane must be within radius, and once h is full, closer than its farthest node
+	full := k > 0 && h.Len() >= k
+	plane := m.axisDistance(coords[n.axis], n.Coordinates[n.axis], n.axis)
+	if plane <= radius && (!full || plane < (*h)[0].Distance) {
+		far.searchAround(coords, m, k, radius, h)
+	}
+}
+
+// Searches Tree for the k nodes closest to coords, using the Tree's Metric, out of the nodes matching
+// the supplied map of dimensional Ranges, as FindRange would return them. Subtrees are skipped if
+// they're outside of ranges, or farther away than the k-th closest match found so far, so the search
+// never has to visit every node in ranges. Returns the nodes sorted by ascending distance from coords,
+// or all matching nodes if there are fewer than k.
+// Returns (nil, nil) if the tree is empty, or (nil, error) if len(coords) != tree dimensions or an
+// axis outside of the tree's dimensions is specified.
+func (t *Tree) KNearestInRange(coords []float64, k int, ranges map[int]Range) ([]*Node, error) {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	if t.Root == nil || k <= 0 {
+		return nil, nil
+	}
+	if err := t.Root.checkDimensions(coords); err != nil {
+		return nil, err
+	}
+	if err := checkRanges(ranges, len(coords)); err != nil {
+		return nil, err
+	}
+
+	m := newSearchMetric(t.metric())
+	h := make(neighborHeap, 0, k)
+	t.Root.kNearestInRange(coords, m, k, ranges, &h)
+	if h.Len() == 0 {
+		return nil, nil
+	}
+	return neighborNodes(h.sorted(m), nil)
+}
+
+// Searches (sub)tree for the k nodes closest to coords out of those within ranges, in the same way
+// as kNearest, but also skips subtrees on the other side of a splitting plane from ranges.
+// Axes in ranges must already be checked.
+func (n *Node) kNearestInRange(coords []float64, m searchMetric, k int, ranges map[int]Range, h *neighborHeap) {
+	if n == nil {
+		return
+	}
+
+	split := n.Coordinates[n.axis]
+	r, ok := ranges[n.axis]
+	searchLeft := !ok || r.reachesLeft(split)
+	searchRight := !ok || r.reachesRight(split, true)
+
+	near, far := n.leftChild, n.rightChild
+	searchNear, searchFar := searchLeft, searchRight
+	if coords[n.axis] >= split {
+		near, far = far, near
+		searchNear, searchFar = searchFar, searchNear
+	}
+	if searchNear {
+		near.kNearestInRange(coords, m, k, ranges, h)
+	}
+
+	if n.inRanges(ranges) {
+		h.offer(n, m.distance(coords, n.Coordinates), k)
+	}
+	for _, b := range n.bucket {
+		if b.inRanges(ranges) {
+			h.offer(b, m.distance(coords, b.Coordinates), k)
+		}
+	}
+	if searchFar && (h.Len() < k || m.axisDistance(coords[n.axis], split, n.axis) < (*h)[0].Distance) {
+		far.kNearestInRange(coords, m, k, ranges, h)
+	}
+}
+
+// Searches Tree for the k nodes farthest from coords, using the Tree's Metric. Returns the nodes with
+// their distances from coords, sorted by descending distance, or all nodes in the tree if there are
+// fewer than k. Returns (nil, nil) if the tree is empty, or (nil, error) if len(coords) != tree dimensions.
+//
+// Subtrees are skipped once k nodes have been found, if the farthest corner of the subtree's bounding
+// box is closer than the k-th farthest of them. Boxes are bounded by the splitting planes above each
+// subtree and the extent of the whole tree, found as FindMin and FindMax find it, so they don't need to
+// be stored on nodes. The farthest point of a box is only known to be a corner for metrics that grow
+// with the difference on every axis, as all of this package's Metrics except WrappedMetric do, so with
+// any other Metric every node is checked.
+func (t *Tree) KFarthest(coords []float64, k int) ([]Neighbor, error) {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	if t.Root == nil || k <= 0 {
+		return nil, nil
+	}
+	if err := t.Root.checkDimensions(coords); err != nil {
+		return nil, err
+	}
+
+	s := &farthestSearch{coords: coords, metric: newSearchMetric(t.metric()), k: k, h: make(neighborHeap, 0, k)}
+	if growsOnEveryAxis(s.metric.Metric) {
+		s.lower, s.upper = make([]float64, len(coords)), make([]float64, len(coords))
+		s.corner = make([]float64, len(coords))
+		for a := range coords {
+			s.lower[a] = t.Root.findMin(a).Coordinates[a]
+			s.upper[a] = t.Root.findMax(a).Coordinates[a]
+		}
+	}
+	s.search(t.Root)
+
+	// candidates were kept with negated distances, so popping the heap yields the closest first
+	result := make([]Neighbor, s.h.Len())
+	for i := len(result) - 1; i >= 0; i-- {
+		result[i] = heap.Pop(&s.h).(Neighbor)
+		result[i].Distance = s.metric.fromReduced(-result[i].Distance)
+	}
+	return result, nil
+}
+
+// State of a search for the k nodes farthest from coords.
+type farthestSearch struct {
+	coords []float64
+	metric searchMetric
+	k      int
+
+	// the farthest candidates found so far, with negated reduced distances, so the heap's farthest
+	// entry is the closest candidate, which is replaced when a farther node is found
+	h neighborHeap
+
+	// bounding box of the subtree being searched, nil if subtrees can't be skipped, and a buffer
+	// for its farthest corner from coords
+	lower, upper, corner []float64
+}
+
+// Searches (sub)tree for nodes farther from coords than the closest of the candidates found so far.
+func (s *farthestSearch) search(n *Node) {
+	if n == nil {
+		return
+	}
+	if s.lower != nil && s.h.Len() >= s.k {
+		for a, c := range s.coords {
+			if c-s.lower[a] > s.upper[a]-c {
+				s.corner[a] = s.lower[a]
+			} else {
+				s.corner[a] = s.upper[a]
+			}
+		}
+		if s.metric.distance(s.coords, s.corner) < -s.h[0].Distance {
+			return
+		}
+	}
+
+	s.h.offer(n, -s.metric.distance(s.coords, n.Coordinates), s.k)
+	for _, b := range n.bucket {
+		s.h.offer(b, -s.metric.distance(s.coords, b.Coordinates), s.k)
+	}
+	if s.lower == nil {
+		s.search(n.leftChild)
+		s.search(n.rightChild)
+		return
+	}
+
+	// search the side away from coords first, as it holds the farthest nodes, narrowing the box to
+	// each subtree's side of the splitting plane
+	axis, split := n.axis, n.Coordinates[n.axis]
+	searchLeft := func() {
+		old := s.upper[axis]
+		s.upper[axis] = math.Min(old, split)
+		s.search(n.leftChild)
+		s.upper[axis] = old
+	}
+	searchRight := func() {
+		old := s.lower[axis]
+		s.lower[axis] = math.Max(old, split)
+		s.search(n.rightChild)
+		s.lower[axis] = old
+	}
+	if s.coords[axis] >= split {
+		searchLeft()
+		searchRight()
+	} else {
+		searchRight()
+		searchLeft()
+	}
+}
+
+// Returns an iterator over the Tree's nodes in ascending distance from coords, using the Tree's
+// Metric, for searches which stop on a condition of their own rather than after a fixed number of
+// neighbors. The search is best-first: subtrees are queued by the least distance any node in them
+// could be from coords, and only opened up as Next reaches that distance, so stopping early leaves
+// the rest of the tree unsearched. If len(coords) != tree dimensions, the iterator yields nothing
+// and Err returns the error.
+//
+// Each call to Next read locks the Tree, but the iterator keeps its place between calls, so the
+// Tree must not be changed until the iterator is finished with.
+func (t *Tree) NearestIterator(coords []float64) *NearestIter {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	it := &NearestIter{tree: t, coords: coords, metric: newSearchMetric(t.metric())}
+	if t.Root == nil {
+		return it
+	}
+	if it.err = t.Root.checkDimensions(coords); it.err != nil {
+		return it
+	}
+	it.queue = iterQueue{{node: t.Root, subtree: true}}
+	return it
+}
+
+// Iterator over a Tree's nodes in ascending distance from a point, returned by Tree.NearestIterator.
+type NearestIter struct {
+	tree   *Tree
+	coords []float64
+	metric searchMetric
+	queue  iterQueue
+	err    error
+}
+
+// Returns the next nearest node and its distance, with true, or (nil, 0, false) once every node has
+// been returned. Nodes at equal distances may be returned in any order.
+func (it *NearestIter) Next() (*Node, float64, bool) {
+	it.tree.Mutex.RLock()
+	defer it.tree.Mutex.RUnlock()
+	for it.queue.Len() > 0 {
+		e := heap.Pop(&it.queue).(iterEntry)
+		if !e.subtree {
+			return e.node, it.metric.fromReduced(e.dist), true
+		}
+
+		// nodes are queued at their own distances, and subtrees at the distance to every splitting
+		// plane between them and coords, which no node in them can be closer than
+		n := e.node
+		heap.Push(&it.queue, iterEntry{node: n, dist: it.metric.distance(it.coords, n.Coordinates)})
+		for _, b := range n.bucket {
+			heap.Push(&it.queue, iterEntry{node: b, dist: it.metric.distance(it.coords, b.Coordinates)})
+		}
+		near, far := n.leftChild, n.rightChild
+		if it.coords[n.axis] >= n.Coordinates[n.axis] {
+			near, far = far, near
+		}
+		if near != nil {
+			heap.Push(&it.queue, iterEntry{node: near, dist: e.dist, subtree: true})
+		}
+		if far != nil {
+			plane := it.metric.axisDistance(it.coords[n.axis], n.Coordinates[n.axis], n.axis)
+			heap.Push(&it.queue, iterEntry{node: far, dist: math.Max(e.dist, plane), subtree: true})
+		}
+	}
+	return nil, 0, false
+}
+
+// Returns the error that stopped the iterator yielding any nodes, or nil if there was none.
+func (it *NearestIter) Err() error {
+	return it.err
+}
+
+// Node or subtree queued by a NearestIter, with the reduced distance of the node, or the least
+// reduced distance any node in the subtree could be from the iterator's coordinates.
+type iterEntry struct {
+	node    *Node
+	dist    float64
+	subtree bool
+}
+
+// Min-heap of iterator entries implementing heap.Interface, so the closest is always at index 0.
+// Nodes come before subtrees at the same distance, so they can be returned without opening the subtree.
+type iterQueue []iterEntry
+
+func (q iterQueue) Len() int {
+	return len(q)
+}
+
+func (q iterQueue) Less(i, j int) bool {
+	if q[i].dist != q[j].dist {
+		return q[i].dist < q[j].dist
+	}
+	return !q[i].subtree && q[j].subtree
+}
+
+func (q iterQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *iterQueue) Push(x interface{}) {
+	*q = append(*q, x.(iterEntry))
+}
+
+func (q *iterQueue) Pop() interface{} {
+	old := *q
+	x := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return x
+}
+
+// Adds node n at distance d to h if it holds fewer than k nodes, or if k <= 0, otherwise replaces
+// the farthest node in h with n if n is closer.
+func (h *neighborHeap) offer(n *Node, d float64, k int) {
+	if k <= 0 || h.Len() < k {
+		heap.Push(h, Neighbor{n, d})
+	} else if d < (*h)[0].Distance {
+		(*h)[0] = Neighbor{n, d}
+		heap.Fix(h, 0)
+	}
+}
+
+// Empties h, returning its neighbors sorted by ascending distance, converted from reduced
+// distances with m.
+func (h *neighborHeap) sorted(m searchMetric) []Neighbor {
+	// popping a max-heap yields the farthest first, so fill the result from the end
+	result := make([]Neighbor, h.Len())
+	for i := len(result) - 1; i >= 0; i-- {
+		result[i] = heap.Pop(h).(Neighbor)
+		result[i].Distance = m.fromReduced(result[i].Distance)
+	}
+	return result
+}
+
+// Max-heap of neighbors implementing heap.Interface, so the farthest candidate is always at
+// index 0 and can be replaced when a closer one is found.
+type neighborHeap []Neighbor
+
+func (h neighborHeap) Len() int {
+	return len(h)
+}
+
+func (h neighborHeap) Less(i, j int) bool {
+	return h[i].Distance > h[j].Distance
+}
+
+func (h neighborHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *neighborHeap) Push(x interface{}) {
+	*h = append(*h, x.(Neighbor))
+}
+
+func (h *neighborHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// Returns an error if coords doesn't have the same number of dimensions as this node.
+func (n *Node) checkDimensions(coords []float64) error {
+	if len(coords) != len(n.Coordinates) {
+		return errors.New("Search coordinates have " + strconv.Itoa(len(coords)) + " dimensions, tree has " + strconv.Itoa(len(n.Coordinates)) + " dimensions.")
+	}
+	return nil
+}
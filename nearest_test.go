@@ -0,0 +1,888 @@
+// Copyright 2012 by Graeme Humphries <graeme@sudo.ca>
+//
+// kdtree is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// kdtree is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with kdtree.  If not, see http://www.gnu.org/licenses/.
+
+package kdtree
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Find the closest node to coords in a list of nodes by checking every one of them,
+// used to verify tree search results.
+func bruteNearest(nl []*Node, coords []float64, m Metric) (*Node, float64) {
+	var best *Node
+	bestDist := math.Inf(1)
+	for _, n := range nl {
+		if d := m.Distance(coords, n.Coordinates); d < bestDist {
+			best, bestDist = n, d
+		}
+	}
+	return best, bestDist
+}
+
+// Returns the k nodes in nl closest to coords by sorting every node by distance, or all of them
+// if there are fewer than k, as an oracle for KNearest.
+func bruteKNearest(nl []*Node, coords []float64, k int, m Metric) []Neighbor {
+	neighbors := make([]Neighbor, len(nl))
+	for i, n := range nl {
+		neighbors[i] = Neighbor{n, m.Distance(coords, n.Coordinates)}
+	}
+	sort.SliceStable(neighbors, func(i, j int) bool {
+		return neighbors[i].Distance < neighbors[j].Distance
+	})
+	if k < len(neighbors) {
+		neighbors = neighbors[:k]
+	}
+	return neighbors
+}
+
+func TestNearestNeighbor(t *testing.T) {
+	nl := genlist(6, 20000)
+	tree := BuildTree(nl)
+
+	for i := 0; i < 1000; i++ {
+		coords := rndCoords(6)
+		n, dist, err := tree.NearestNeighbor(coords)
+		if err != nil {
+			t.Fatal("Error while searching tree:", err)
+		}
+		expected, expectedDist := bruteNearest(nl, coords, EuclideanMetric{})
+		// ties may return a different node at the same distance
+		if n != expected && dist != expectedDist {
+			t.Fatal(strconv.FormatInt(int64(i), 10) + ": nearest to " + String(coords) + " should be " + expected.String() + ", found " + n.String())
+		}
+	}
+
+	// every node in the tree should be its own nearest neighbor
+	for _, n := range nl[:1000] {
+		if found, dist, err := tree.NearestNeighbor(n.Coordinates); err != nil {
+			t.Fatal("Error while searching tree:", err)
+		} else if found != n || dist != 0 {
+			t.Fatal("Nearest to " + n.String() + " should be itself, found " + found.String())
+		}
+	}
+
+	if _, _, err := tree.NearestNeighbor(rndCoords(5)); err == nil {
+		t.Fatal("Searching with the wrong number of dimensions should return an error.")
+	}
+	if n, _, err := new(Tree).NearestNeighbor(rndCoords(6)); n != nil || err != nil {
+		t.Fatal("Searching an empty tree should return (nil, 0, nil).")
+	}
+}
+
+func TestKNearestInRange(t *testing.T) {
+	nl := genlist(4, 20000)
+	tree := BuildTree(nl)
+
+	for i := 0; i < 100; i++ {
+		coords := rndCoords(4)
+		k := rand.Intn(50) + 1
+		ranges := map[int]Range{0: Range{0.2, 0.6}, 2: Range{0.1, 0.4}}
+		results, err := tree.KNearestInRange(coords, k, ranges)
+		if err != nil {
+			t.Fatal("Error while searching tree:", err)
+		}
+
+		// the result distances should match the k smallest distances of nodes in ranges
+		dists := make([]float64, 0, len(nl))
+		for _, n := range nl {
+			if n.inRanges(ranges) {
+				dists = append(dists, (EuclideanMetric{}).Distance(coords, n.Coordinates))
+			}
+		}
+		sort.Float64s(dists)
+		if len(results) != k {
+			t.Fatal("KNearestInRange returned", len(results), "nodes, expected", k)
+		}
+		for j, n := range results {
+			if !n.inRanges(ranges) {
+				t.Fatal("Result", j, "is", n.String(), "which is outside of ranges.")
+			}
+			if d := (EuclideanMetric{}).Distance(coords, n.Coordinates); d != dists[j] {
+				t.Fatal("Result", j, "is", n.String(), "at distance", d, ", expected distance", dists[j])
+			}
+		}
+	}
+
+	if results, err := tree.KNearestInRange(rndCoords(4), 5, map[int]Range{0: Range{2, 3}}); results != nil || err != nil {
+		t.Fatal("Searching with no nodes in ranges should return (nil, nil).")
+	}
+	if _, err := tree.KNearestInRange(rndCoords(4), 5, map[int]Range{4: Range{0, 1}}); err == nil {
+		t.Fatal("Searching with an invalid axis should return an error.")
+	}
+}
+
+func TestSearchAround(t *testing.T) {
+	nl := genlist(4, 20000)
+	tree := BuildTree(nl)
+
+	for i := 0; i < 100; i++ {
+		coords := rndCoords(4)
+		radius := rand.Float64() / 4
+		maxResults := rand.Intn(50)
+		results, err := tree.SearchAround(coords, radius, maxResults)
+		if err != nil {
+			t.Fatal("Error while searching tree:", err)
+		}
+
+		// the results should be the closest of the nodes within radius, in order
+		dists := make([]float64, 0, len(nl))
+		for _, n := range nl {
+			if d := (EuclideanMetric{}).Distance(coords, n.Coordinates); d <= radius {
+				dists = append(dists, d)
+			}
+		}
+		sort.Float64s(dists)
+		if maxResults > 0 && len(dists) > maxResults {
+			dists = dists[:maxResults]
+		}
+		if len(results) != len(dists) {
+			t.Fatal("SearchAround returned", len(results), "nodes, expected", len(dists))
+		}
+		for j, nb := range results {
+			if math.Abs(nb.Distance-dists[j]) > 1e-12 {
+				t.Fatal("Result", j, "is", nb.Node.String(), "at distance", nb.Distance, ", expected distance", dists[j])
+			}
+		}
+	}
+
+	if results, err := tree.SearchAround(rndCoords(4), -1, 10); results != nil || err != nil {
+		t.Fatal("Searching with a negative radius should return (nil, nil).")
+	}
+	if _, err := tree.SearchAround(rndCoords(3), 0.1, 10); err == nil {
+		t.Fatal("Searching with the wrong number of dimensions should return an error.")
+	}
+}
+
+func TestKNearestWithDistance(t *testing.T) {
+	tree := BuildTree(genlist(6, 20000))
+	tree.Metric = ManhattanMetric{}
+	for i := 0; i < 100; i++ {
+		coords := rndCoords(6)
+		neighbors, err := tree.KNearestWithDistance(coords, 20)
+		if err != nil {
+			t.Fatal("Error while searching tree:", err)
+		}
+		nodes, err := tree.KNearest(coords, 20)
+		if err != nil {
+			t.Fatal("Error while searching tree:", err)
+		}
+		if len(neighbors) != len(nodes) {
+			t.Fatal("KNearestWithDistance returned", len(neighbors), "neighbors, KNearest returned", len(nodes))
+		}
+		for j, nb := range neighbors {
+			if nb.Node != nodes[j] {
+				t.Fatal("Neighbor", j, "is", nb.Node.String(), "expected", nodes[j].String())
+			}
+			if d := (ManhattanMetric{}).Distance(coords, nb.Node.Coordinates); nb.Distance != d {
+				t.Fatal("Neighbor", j, "has distance", nb.Distance, "expected", d)
+			}
+			if j > 0 && nb.Distance < neighbors[j-1].Distance {
+				t.Fatal("Neighbors are not sorted by distance.")
+			}
+		}
+	}
+}
+
+func TestKFarthest(t *testing.T) {
+	nl := genlist(3, 5000)
+	tree := BuildTree(nl)
+	for _, m := range []Metric{nil, ManhattanMetric{}, WrappedMetric{Period: []float64{1, 1, 1}}, WeightedMetric{Weights: []float64{3, 1, 0.2}}} {
+		tree.Metric = m
+		for i := 0; i < 50; i++ {
+			coords := rndCoords(3)
+			farthest, err := tree.KFarthest(coords, 10)
+			if err != nil {
+				t.Fatal("Error while searching tree:", err)
+			}
+
+			distances := make([]float64, len(nl))
+			for j, n := range nl {
+				distances[j] = tree.metric().Distance(coords, n.Coordinates)
+			}
+			sort.Sort(sort.Reverse(sort.Float64Slice(distances)))
+			if len(farthest) != 10 {
+				t.Fatal("KFarthest returned", len(farthest), "nodes, expected 10")
+			}
+			for j, nb := range farthest {
+				if math.Abs(nb.Distance-distances[j]) > 1e-12 {
+					t.Fatal("Farthest node", j, "is at distance", nb.Distance, "expected", distances[j])
+				}
+			}
+		}
+	}
+
+	if all, _ := BuildTree(genlist(3, 5)).KFarthest(rndCoords(3), 10); len(all) != 5 {
+		t.Fatal("KFarthest should return every node of a small tree, returned", len(all))
+	}
+	if _, err := tree.KFarthest(rndCoords(2), 10); err == nil {
+		t.Fatal("Searching with the wrong number of dimensions should return an error.")
+	}
+}
+
+func TestNearestNeighborSquared(t *testing.T) {
+	tree := BuildTree(genlist(6, 20000))
+	for i := 0; i < 1000; i++ {
+		coords := rndCoords(6)
+		n, dist, err := tree.NearestNeighbor(coords)
+		if err != nil {
+			t.Fatal("Error while searching tree:", err)
+		}
+		n2, dist2, err := tree.NearestNeighborSquared(coords)
+		if err != nil {
+			t.Fatal("Error while searching tree:", err)
+		}
+		if n != n2 || math.Abs(dist*dist-dist2) > 1e-12 {
+			t.Fatal("NearestNeighborSquared found "+n2.String()+" at", dist2, "expected "+n.String()+" at", dist*dist)
+		}
+	}
+}
+
+func TestKNearest(t *testing.T) {
+	nl := genlist(6, 20000)
+	tree := BuildTree(nl)
+
+	for i := 0; i < 100; i++ {
+		coords := rndCoords(6)
+		k := rand.Intn(50) + 1
+		results, err := tree.KNearest(coords, k)
+		if err != nil {
+			t.Fatal("Error while searching tree:", err)
+		}
+		if len(results) != k {
+			t.Fatal("KNearest returned", len(results), "nodes, expected", k)
+		}
+
+		// the results should be sorted, and at the same distances as a brute force search's,
+		// though ties may be broken differently
+		expected := bruteKNearest(nl, coords, k, EuclideanMetric{})
+		for j, n := range results {
+			d := (EuclideanMetric{}).Distance(coords, n.Coordinates)
+			if d != expected[j].Distance {
+				t.Fatal("Result", j, "is", n.String(), "at distance", d, ", expected distance", expected[j].Distance)
+			}
+			if j > 0 && d < (EuclideanMetric{}).Distance(coords, results[j-1].Coordinates) {
+				t.Fatal("Results are not sorted by ascending distance.")
+			}
+		}
+	}
+
+	// asking for more nodes than the tree has should return all of them
+	small := BuildTree(genlist(6, 10))
+	if results, err := small.KNearest(rndCoords(6), 20); err != nil {
+		t.Fatal("Error while searching tree:", err)
+	} else if len(results) != 10 {
+		t.Fatal("KNearest returned", len(results), "nodes from a tree of 10")
+	}
+	if _, err := tree.KNearest(rndCoords(5), 5); err == nil {
+		t.Fatal("Searching with the wrong number of dimensions should return an error.")
+	}
+}
+
+func TestNearestMatching(t *testing.T) {
+	nl := make([]*Node, 20000)
+	for i := range nl {
+		nl[i] = NewNodeWithValue(rndCoords(6), i%7 == 0)
+	}
+	tree := BuildTree(nl)
+	pred := func(n *Node) bool {
+		return n.Value.(bool)
+	}
+	matching := make([]*Node, 0, len(nl))
+	for _, n := range nl {
+		if pred(n) {
+			matching = append(matching, n)
+		}
+	}
+
+	for i := 0; i < 1000; i++ {
+		coords := rndCoords(6)
+		n, dist, err := tree.NearestMatching(coords, pred)
+		if err != nil {
+			t.Fatal("Error while searching tree:", err)
+		}
+		if expected, expectedDist := bruteNearest(matching, coords, EuclideanMetric{}); n != expected && dist != expectedDist {
+			t.Fatal("Nearest matching node to " + String(coords) + " should be " + expected.String() + ", found " + n.String())
+		}
+	}
+
+	never := func(*Node) bool {
+		return false
+	}
+	if n, dist, err := tree.NearestMatching(rndCoords(6), never); n != nil || dist != 0 || err != nil {
+		t.Fatal("Searching with no matching nodes should return (nil, 0, nil).")
+	}
+}
+
+func TestNearestExcluding(t *testing.T) {
+	nl := genlist(6, 20000)
+	tree := BuildTree(nl)
+
+	for _, n := range nl[:1000] {
+		others := make([]*Node, 0, len(nl)-1)
+		for _, o := range nl {
+			if o != n {
+				others = append(others, o)
+			}
+		}
+		nearest, dist, err := tree.NearestExcluding(n)
+		if err != nil {
+			t.Fatal("Error while searching tree:", err)
+		}
+		if expected, expectedDist := bruteNearest(others, n.Coordinates, EuclideanMetric{}); nearest != expected || dist != expectedDist {
+			t.Fatal("Nearest node to " + n.String() + " should be " + expected.String() + ", found " + nearest.String())
+		}
+	}
+
+	single := BuildTree([]*Node{NewNode(rndCoords(6))})
+	if n, dist, err := single.NearestExcluding(single.Root); n != nil || dist != 0 || err != nil {
+		t.Fatal("Searching a tree with no other nodes should return (nil, 0, nil).")
+	}
+	if _, _, err := tree.NearestExcluding(nil); err == nil {
+		t.Fatal("Searching for the neighbor of a nil node should return an error.")
+	}
+}
+
+func TestApproxNearest(t *testing.T) {
+	nl := genlist(6, 20000)
+	tree := BuildTree(nl)
+
+	for i := 0; i < 1000; i++ {
+		coords := rndCoords(6)
+		exact, exactDist, err := tree.NearestNeighbor(coords)
+		if err != nil {
+			t.Fatal("Error while searching tree:", err)
+		}
+		if n, dist, err := tree.ApproxNearest(coords, 0); err != nil {
+			t.Fatal("Error while searching tree:", err)
+		} else if n != exact || dist != exactDist {
+			t.Fatal("ApproxNearest with epsilon 0 found " + n.String() + ", NearestNeighbor found " + exact.String())
+		}
+		if n, dist, err := tree.ApproxNearest(coords, 0.5); err != nil {
+			t.Fatal("Error while searching tree:", err)
+		} else if dist > exactDist*1.5 {
+			t.Fatal("ApproxNearest found "+n.String()+" at distance", dist, "more than 1.5 times the nearest distance", exactDist)
+		}
+	}
+
+	if _, _, err := tree.ApproxNearest(rndCoords(6), -1); err == nil {
+		t.Fatal("Searching with a negative epsilon should return an error.")
+	}
+}
+
+// Searches using each of the built-in metrics should match a brute force search of the node list.
+func TestMetrics(t *testing.T) {
+	nl := genlist(4, 5000)
+	tree := BuildTree(nl)
+	metrics := []Metric{EuclideanMetric{}, ManhattanMetric{}, MinkowskiMetric{3}, ChebyshevMetric{}, WrappedMetric{[]float64{1, 1, 0, 1}},
+		WeightedMetric{[]float64{5, 1, 0.5, 1}}}
+
+	for _, m := range metrics {
+		tree.Metric = m
+		for i := 0; i < 100; i++ {
+			coords := rndCoords(4)
+			n, dist, err := tree.NearestNeighbor(coords)
+			if err != nil {
+				t.Fatal("Error while searching tree:", err)
+			}
+			if expected, expectedDist := bruteNearest(nl, coords, m); n != expected && dist != expectedDist {
+				t.Fatal("Nearest to", String(coords), "should be", expected.String(), "found", n.String())
+			}
+
+			radius := rand.Float64() / 2
+			results, err := tree.FindWithinRadius(coords, radius)
+			if err != nil {
+				t.Fatal("Error while searching tree:", err)
+			}
+			count := 0
+			for _, n := range nl {
+				if m.Distance(coords, n.Coordinates) <= radius {
+					count++
+				}
+			}
+			if len(results) != count {
+				t.Fatal("FindWithinRadius returned", len(results), "nodes, expected", count)
+			}
+		}
+	}
+}
+
+// Trees without a Metric should search with DefaultMetric, and trees with one should ignore it.
+func TestDefaultMetric(t *testing.T) {
+	defer func(m Metric) { DefaultMetric = m }(DefaultMetric)
+	DefaultMetric = ManhattanMetric{}
+
+	tree := BuildTree([]*Node{NewNode([]float64{0, 0}), NewNode([]float64{3, 3})})
+	if _, dist, _ := tree.NearestNeighbor([]float64{1, 2}); dist != 3 {
+		t.Fatal("Tree without a Metric found distance", dist, "expected Manhattan distance 3")
+	}
+	tree.Metric = ChebyshevMetric{}
+	if _, dist, _ := tree.NearestNeighbor([]float64{1, 2}); dist != 2 {
+		t.Fatal("Tree with a Metric found distance", dist, "expected Chebyshev distance 2")
+	}
+	DefaultMetric, tree.Metric = nil, nil
+	if _, dist, _ := tree.NearestNeighbor([]float64{1, 2}); dist != math.Sqrt(5) {
+		t.Fatal("Tree without a Metric or DefaultMetric found distance", dist, "expected Euclidean distance", math.Sqrt(5))
+	}
+}
+
+// Nodes near opposite edges of a wrapped axis should be found as neighbors.
+func TestWrappedMetric(t *testing.T) {
+	nl := genlist(2, 5000)
+	edge := NewNode([]float64{0.999, 0.5})
+	tree := BuildTree(append(nl, edge))
+	tree.Metric = WrappedMetric{[]float64{1, 1}}
+
+	// edge is only 0.0015 away, so the nearest node is at most that far, possibly across the edge
+	coords := []float64{0.0005, 0.5}
+	if n, dist, err := tree.NearestNeighbor(coords); err != nil {
+		t.Fatal("Error while searching tree:", err)
+	} else if expected, _ := bruteNearest(append(nl, edge), coords, tree.Metric); n != expected || dist > 0.0015+1e-9 {
+		t.Fatal("Nearest to ( 0.0005 0.5 ) should be "+expected.String()+", found "+n.String()+" at distance", dist)
+	}
+
+	for i := 0; i < 100; i++ {
+		coords := []float64{rand.Float64() / 100, 1 - rand.Float64()/100}
+		results, err := tree.KNearest(coords, 10)
+		if err != nil {
+			t.Fatal("Error while searching tree:", err)
+		}
+		sorted := make([]*Node, len(tree.Root.nodeList()))
+		copy(sorted, tree.Root.nodeList())
+		sort.Slice(sorted, func(i, j int) bool {
+			return tree.Metric.Distance(coords, sorted[i].Coordinates) < tree.Metric.Distance(coords, sorted[j].Coordinates)
+		})
+		for j, n := range results {
+			if n != sorted[j] {
+				t.Fatal("Neighbor", j, "of", String(coords), "should be", sorted[j].String(), "found", n.String())
+			}
+		}
+	}
+}
+
+// Minkowski distances with P = 1 and P = 2 should exactly match Manhattan and Euclidean distances.
+func TestMinkowskiMetric(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		a, b := rndCoords(6), rndCoords(6)
+		if d1, d2 := (MinkowskiMetric{1}).Distance(a, b), (ManhattanMetric{}).Distance(a, b); d1 != d2 {
+			t.Fatal("Minkowski distance with P = 1 is", d1, "Manhattan distance is", d2)
+		}
+		if d1, d2 := (MinkowskiMetric{2}).Distance(a, b), (EuclideanMetric{}).Distance(a, b); d1 != d2 {
+			t.Fatal("Minkowski distance with P = 2 is", d1, "Euclidean distance is", d2)
+		}
+	}
+}
+
+// Weighted distances should scale each axis by its weight, and match Euclidean distances when
+// every weight is 1 or missing.
+func TestWeightedMetric(t *testing.T) {
+	m := WeightedMetric{[]float64{2, 0.5}}
+	if d := m.Distance([]float64{0, 0, 0}, []float64{1.5, 8, 1}); d != math.Sqrt(9+16+1) {
+		t.Fatal("Weighted distance is", d, "expected", math.Sqrt(9+16+1))
+	}
+	if d := m.AxisDistance(3, 1, 1); d != 1 {
+		t.Fatal("Weighted axis distance is", d, "expected 1")
+	}
+	for i := 0; i < 1000; i++ {
+		a, b := rndCoords(6), rndCoords(6)
+		if d1, d2 := (WeightedMetric{[]float64{1, 1, 1}}).Distance(a, b), (EuclideanMetric{}).Distance(a, b); d1 != d2 {
+			t.Fatal("Weighted distance with weights of 1 is", d1, "Euclidean distance is", d2)
+		}
+	}
+}
+
+// Box distance bounds should hold for every point in the box, and be reached by some point in it
+// for metrics that grow on every axis.
+func TestDistToBox(t *testing.T) {
+	metrics := []Metric{nil, ManhattanMetric{}, ChebyshevMetric{}, MinkowskiMetric{3}, WeightedMetric{[]float64{2, 1, 0.5}},
+		WrappedMetric{[]float64{1, 1, 1}}}
+	for _, m := range metrics {
+		for i := 0; i < 100; i++ {
+			point := []float64{rand.Float64()*3 - 1, rand.Float64()*3 - 1, rand.Float64()*3 - 1}
+			box := make([]Range, 3)
+			for a := range box {
+				box[a], _ = NewRange(rand.Float64(), rand.Float64())
+			}
+			min, max := MinDistToBox(point, box, m), MaxDistToBox(point, box, m)
+			for j := 0; j < 100; j++ {
+				inside := make([]float64, 3)
+				for a, r := range box {
+					inside[a] = r.Min + rand.Float64()*(r.Max-r.Min)
+				}
+				if d := orDefault(m).Distance(point, inside); d < min-1e-12 || d > max+1e-12 {
+					t.Fatal("Point in box at distance", d, "outside of bounds", min, max)
+				}
+			}
+			if m == nil {
+				nearest := make([]float64, 3)
+				for a, r := range box {
+					nearest[a] = math.Max(r.Min, math.Min(point[a], r.Max))
+				}
+				if d := (EuclideanMetric{}).Distance(point, nearest); d != min {
+					t.Fatal("Nearest point of box at distance", d, "MinDistToBox returned", min)
+				}
+			}
+		}
+	}
+	if d := MaxDistToBox([]float64{0}, []Range{{0, 1}}, WrappedMetric{[]float64{1}}); !math.IsInf(d, 1) {
+		t.Fatal("MaxDistToBox with a wrapped metric should be +Inf, got", d)
+	}
+	if d := MinDistToBox([]float64{0, 0}, []Range{{1, 2}, {-1, 1}}, nil); d != 1 {
+		t.Fatal("MinDistToBox returned", d, "expected 1")
+	}
+	if d := MaxDistToBox([]float64{0, 0}, []Range{{1, 2}, {-1, 1}}, ManhattanMetric{}); d != 3 {
+		t.Fatal("MaxDistToBox returned", d, "expected 3")
+	}
+}
+
+func TestNearestBatch(t *testing.T) {
+	tree := BuildTree(genlist(6, 20000))
+	queries := make([][]float64, 1000)
+	for i := range queries {
+		queries[i] = rndCoords(6)
+	}
+	results, err := tree.NearestBatch(queries, 4)
+	if err != nil {
+		t.Fatal("Error while searching tree:", err)
+	}
+	if len(results) != len(queries) {
+		t.Fatal("NearestBatch returned", len(results), "results for", len(queries), "queries.")
+	}
+	for i, coords := range queries {
+		if n, _, _ := tree.NearestNeighbor(coords); results[i] != n {
+			t.Fatal("Result", i, "is", results[i].String(), "NearestNeighbor found", n.String())
+		}
+	}
+
+	if _, err := tree.NearestBatch(append(queries, rndCoords(5)), 4); err == nil {
+		t.Fatal("Searching with the wrong number of dimensions should return an error.")
+	}
+}
+
+// An iterator should yield every node once, in the same order as a brute force sort by distance.
+func TestNearestIterator(t *testing.T) {
+	nl := genlist(3, 2000)
+	for _, tree := range []*Tree{BuildTree(nl), BuildTreeBucket(genlist(3, 2000), 8)} {
+		for _, m := range []Metric{nil, ManhattanMetric{}} {
+			tree.Metric = m
+			coords := rndCoords(3)
+			expected := bruteKNearest(tree.NodeList(), coords, tree.Size(), tree.metric())
+			it := tree.NearestIterator(coords)
+			seen := make(map[*Node]bool)
+			for i := range expected {
+				n, dist, ok := it.Next()
+				if !ok {
+					t.Fatal("Iterator stopped after", i, "of", len(expected), "nodes")
+				}
+				if seen[n] {
+					t.Fatal(n.String() + " returned twice.")
+				}
+				seen[n] = true
+				if math.Abs(dist-expected[i].Distance) > 1e-12 {
+					t.Fatal("Node", i, "is at distance", dist, "expected", expected[i].Distance)
+				}
+			}
+			if _, _, ok := it.Next(); ok {
+				t.Fatal("Iterator returned more nodes than the tree has.")
+			}
+		}
+	}
+
+	if _, _, ok := new(Tree).NearestIterator(rndCoords(3)).Next(); ok {
+		t.Fatal("Iterating over an empty tree should return nothing.")
+	}
+	it := BuildTree(nl).NearestIterator(rndCoords(2))
+	if _, _, ok := it.Next(); ok || it.Err() == nil {
+		t.Fatal("Iterating with the wrong number of dimensions should return nothing, with an error.")
+	}
+}
+
+func TestAllByDistance(t *testing.T) {
+	tree := BuildTreeBucket(genlist(3, 2000), 4)
+	tree.Metric = ManhattanMetric{}
+	coords := rndCoords(3)
+	all, err := tree.AllByDistance(coords)
+	if err != nil {
+		t.Fatal("Error while searching tree:", err)
+	}
+	expected := bruteKNearest(tree.NodeList(), coords, tree.Size(), ManhattanMetric{})
+	if len(all) != len(expected) {
+		t.Fatal("AllByDistance returned", len(all), "nodes, tree has", len(expected))
+	}
+	seen := make(map[*Node]bool)
+	for i, nb := range all {
+		if seen[nb.Node] {
+			t.Fatal(nb.Node.String() + " returned twice.")
+		}
+		seen[nb.Node] = true
+		if math.Abs(nb.Distance-expected[i].Distance) > 1e-12 {
+			t.Fatal("Node", i, "is at distance", nb.Distance, "expected", expected[i].Distance)
+		}
+	}
+
+	if all, err := new(Tree).AllByDistance(coords); all != nil || err != nil {
+		t.Fatal("Searching an empty tree should return (nil, nil).")
+	}
+	if _, err := tree.AllByDistance(rndCoords(2)); err == nil {
+		t.Fatal("Searching with the wrong number of dimensions should return an error.")
+	}
+}
+
+// Every node should be joined to a node as close as its nearest neighbor in the other tree.
+func TestNearestJoin(t *testing.T) {
+	readings := BuildTree(genlist(3, 5000))
+	stations := BuildTreeBucket(genlist(3, 2000), 4)
+	join, err := readings.NearestJoin(stations)
+	if err != nil {
+		t.Fatal("Error while joining trees:", err)
+	}
+	if len(join) != readings.Size() {
+		t.Fatal("NearestJoin returned", len(join), "pairs for", readings.Size(), "nodes")
+	}
+	for _, n := range readings.NodeList() {
+		_, dist, _ := stations.NearestNeighbor(n.Coordinates)
+		if d := (EuclideanMetric{}).Distance(n.Coordinates, join[n].Coordinates); d != dist {
+			t.Fatal(n.String()+" joined to "+join[n].String()+" at distance", d, "nearest is at", dist)
+		}
+	}
+
+	if self, _ := stations.NearestJoin(stations); len(self) != stations.Size() {
+		t.Fatal("Joining a tree with itself returned", len(self), "pairs for", stations.Size(), "nodes")
+	}
+	if empty, err := readings.NearestJoin(new(Tree)); err != nil || len(empty) != 0 {
+		t.Fatal("Joining with an empty tree should return no pairs, got", len(empty), "and error", err)
+	}
+	if _, err := readings.NearestJoin(BuildTree(genlist(2, 10))); err != ErrDimensionMismatch {
+		t.Fatal("Joining trees with different dimensions should return ErrDimensionMismatch, got", err)
+	}
+}
+
+func TestNearestBatchContext(t *testing.T) {
+	tree := BuildTree(genlist(6, 20000))
+	queries := make([][]float64, 1000)
+	for i := range queries {
+		queries[i] = rndCoords(6)
+	}
+	results, err := tree.NearestBatchContext(context.Background(), queries, 4)
+	if err != nil {
+		t.Fatal("Error while searching tree:", err)
+	}
+	for i, coords := range queries {
+		if n, dist, _ := tree.NearestNeighbor(coords); results[i].Node != n || results[i].Distance != dist {
+			t.Fatal("Result", i, "is", results[i].Node.String(), "at", results[i].Distance, "NearestNeighbor found", n.String(), "at", dist)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if results, err := tree.NearestBatchContext(ctx, queries, 4); results != nil || err != context.Canceled {
+		t.Fatal("Searching with a cancelled context should return (nil, context.Canceled), got error", err)
+	}
+
+	// cancel part way through a batch too large to finish first
+	ctx, cancel = context.WithCancel(context.Background())
+	many := make([][]float64, 1000000)
+	for i := range many {
+		many[i] = queries[i%len(queries)]
+	}
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if results, err := tree.NearestBatchContext(ctx, many, 2); results != nil || err != context.Canceled {
+		t.Fatal("Cancelling a batch should return (nil, context.Canceled), got error", err)
+	}
+
+	// a context cancelled only after every query was searched shouldn't fail the batch
+	late := &lateContext{Context: context.Background(), after: int32(1 + len(queries))}
+	if results, err := tree.NearestBatchContext(late, queries, 4); err != nil || len(results) != len(queries) {
+		t.Fatal("A batch that finished before it was cancelled should return its results, got error", err)
+	}
+}
+
+// Context that reports itself cancelled once Err has been called more than after times, without ever
+// closing its Done channel, to cancel at a point set by how often a search checks it.
+type lateContext struct {
+	context.Context
+	after, calls int32
+}
+
+func (c *lateContext) Err() error {
+	if atomic.AddInt32(&c.calls, 1) > c.after {
+		return context.Canceled
+	}
+	return nil
+}
+
+// The trace should hold the nodes examined, each once, ending with the nearest node's subtree pruned
+// down to far fewer nodes than the tree holds.
+func TestNearestNeighborTrace(t *testing.T) {
+	nl := genlist(3, 10000)
+	tree := BuildTreeBucket(nl, 4)
+	total := 0
+	for i := 0; i < 100; i++ {
+		coords := rndCoords(3)
+		n, dist, trace, err := tree.NearestNeighborTrace(coords)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, expectedDist, _ := tree.NearestNeighbor(coords); n != expected || dist != expectedDist {
+			t.Fatal("Traced search found", n.String(), "at", dist, "NearestNeighbor found", expected.String(), "at", expectedDist)
+		}
+		seen := make(map[*Node]bool, len(trace))
+		for _, v := range trace {
+			if seen[v] {
+				t.Fatal(v.String() + " was examined twice.")
+			}
+			seen[v] = true
+		}
+		if !seen[n] || trace[0] == tree.Root {
+			t.Fatal("Trace should include the nearest node, and start at a leaf rather than the root.")
+		}
+		total += len(trace)
+	}
+	if total > 100*len(nl)/10 {
+		t.Fatal("Searches examined", total/100, "nodes on average, out of", len(nl))
+	}
+
+	if _, _, trace, err := new(Tree).NearestNeighborTrace(rndCoords(3)); trace != nil || err != nil {
+		t.Fatal("Tracing a search of an empty tree should return a nil trace, got", trace, err)
+	}
+	if _, _, _, err := tree.NearestNeighborTrace(rndCoords(2)); err == nil {
+		t.Fatal("Tracing a search with the wrong number of dimensions should return an error.")
+	}
+}
+
+// Trees with more dimensions than their LinearScanDimensions should be scanned, finding the same
+// nearest nodes as searching the tree. Moving a node without the tree knowing hides it from tree
+// searches, but not from a scan, which shows which one was used.
+func TestLinearScan(t *testing.T) {
+	nl := genlist(20, 2000)
+	tree := BuildTree(nl)
+	for _, limit := range []int{0, -1, 30} {
+		tree.LinearScanDimensions = limit
+		for i := 0; i < 100; i++ {
+			coords := rndCoords(20)
+			n, dist, err := tree.NearestNeighbor(coords)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected, expectedDist := bruteNearest(nl, coords, EuclideanMetric{}); n != expected && dist != expectedDist {
+				t.Fatal("Nearest to " + String(coords) + " should be " + expected.String() + ", found " + n.String())
+			}
+			neighbors, err := tree.KNearestWithDistance(coords, 5)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for j, nb := range bruteKNearest(nl, coords, 5, EuclideanMetric{}) {
+				if neighbors[j].Distance != nb.Distance {
+					t.Fatal("Neighbor", j, "of", String(coords), "is at", neighbors[j].Distance, "expected", nb.Distance)
+				}
+			}
+		}
+	}
+
+	hidden := nl[0]
+	hidden.Coordinates = make([]float64, 20)
+	for i := range hidden.Coordinates {
+		hidden.Coordinates[i] = 2
+	}
+	tree.LinearScanDimensions = 10
+	if n, _, _ := tree.NearestNeighbor(hidden.Coordinates); n != hidden {
+		t.Fatal("A tree with more dimensions than its LinearScanDimensions wasn't scanned.")
+	}
+	if nodes, _ := tree.KNearest(hidden.Coordinates, 1); nodes[0] != hidden {
+		t.Fatal("KNearest didn't scan a tree with more dimensions than its LinearScanDimensions.")
+	}
+}
+
+func BenchmarkNearestBatch(b *testing.B) {
+	b.StopTimer()
+	nl := genlist(6, b.N*2)
+	tree := BuildTree(nl)
+	queries := make([][]float64, b.N)
+	for i := range queries {
+		queries[i] = rndCoords(6)
+	}
+	b.StartTimer()
+
+	if _, err := tree.NearestBatch(queries, 0); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkNearestNeighbor(b *testing.B) {
+	b.StopTimer()
+	nl := genlist(6, b.N*2)
+	tree := BuildTree(nl)
+	b.StartTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, _, err := tree.NearestNeighbor(rndCoords(6)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Compares searching a tree in many dimensions, where little can be pruned, with scanning it.
+func BenchmarkNearestNeighborHighDimensions(b *testing.B) {
+	tree := BuildTree(genlist(24, 20000))
+	for _, bench := range []struct {
+		name  string
+		limit int
+	}{{"scan", 0}, {"tree", -1}} {
+		tree.LinearScanDimensions = bench.limit
+		b.Run(bench.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, _, err := tree.NearestNeighbor(rndCoords(24)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// Runs a KNearest search from a separate goroutine for every query, all sharing the tree's read lock,
+// in the same way as BenchmarkFind. Run with -race to check searches don't write to shared nodes.
+// Each goroutine sends back nil or its error, as b.Fatal must be called from the benchmark's own.
+func BenchmarkKNearest(b *testing.B) {
+	b.StopTimer()
+	nl := genlist(6, b.N)
+	tree := BuildTree(nl)
+	donechan := make(chan error, 100)
+	b.StartTimer()
+	for _, n := range nl {
+		go func() {
+			if result, err := tree.KNearest(n.Coordinates, 5); err != nil {
+				donechan <- errors.New("Error while searching tree: " + err.Error())
+			} else if len(result) == 0 || !equal_fl(result[0].Coordinates, n.Coordinates) {
+				donechan <- errors.New(n.String() + " should be its own nearest neighbor.")
+			} else {
+				donechan <- nil
+			}
+		}()
+	}
+	// wait for goroutines to finish
+	for range nl {
+		if err := <-donechan; err != nil {
+			b.Fatal(err)
+		}
+	}
+}
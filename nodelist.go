@@ -17,28 +17,148 @@ package kdtree
 
 import (
 	"errors"
+	"math/rand"
+	"strconv"
 )
 
 /***** Node list management functions *****/
 
 // Returns a slice of all distinct nodes in the tree. This is done by a tree traversal,
-// and will be equally slow.
+// and will be equally slow. The slice is allocated once, at the size of the tree.
 func (t *Tree) NodeList() []*Node {
 	t.Mutex.RLock()
 	defer t.Mutex.RUnlock()
-	return t.Root.nodeList()
+	return t.Root.nodeListInto(make([]*Node, 0, t.count))
+}
+
+// Returns the same nodes as NodeList, but stores them in buf, reusing its memory if it has enough
+// capacity, so callers listing a tree repeatedly can avoid allocating a new slice each time.
+// Any nodes already in buf are overwritten. The returned slice shares buf's memory unless it
+// had to grow.
+func (t *Tree) NodeListInto(buf []*Node) []*Node {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	if cap(buf) < t.count {
+		buf = make([]*Node, 0, t.count)
+	}
+	return t.Root.nodeListInto(buf[:0])
+}
+
+// Returns a copy of the coordinates of every node in the tree, one row per node, in the same order
+// NodeList lists the nodes, for passing the points to code that doesn't use Nodes. Every row is
+// copied into a single array allocated for the whole tree, so changing them doesn't affect the tree.
+// Returns nil if the tree is empty.
+func (t *Tree) Coordinates() [][]float64 {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	if t.Root == nil {
+		return nil
+	}
+	dimensions := len(t.Root.Coordinates)
+	all := make([]float64, 0, t.count*dimensions)
+	rows := make([][]float64, 0, t.count)
+	t.Root.traverse(func(n *Node) {
+		start := len(all)
+		all = append(all, n.Coordinates...)
+		// capped, so appending to one row can't overwrite the next
+		rows = append(rows, all[start:len(all):len(all)])
+	})
+	return rows
+}
+
+// Orders in which NodeListOrdered lists a tree's nodes. Nodes in a bucket are listed next to the
+// node holding them: just after it in PreOrder and InOrder, and just before it in PostOrder.
+type TraversalOrder int
+
+const (
+	PostOrder TraversalOrder = iota // left subtree, right subtree, then the node, as NodeList lists them
+	PreOrder                        // the node, then its left subtree, then its right subtree
+	InOrder                         // left subtree, the node, then its right subtree
+)
+
+// Returns a slice of all distinct nodes in the tree, listed in the given order, which makes the
+// output reproducible for serialization and tests. Unknown orders list the nodes in PostOrder.
+func (t *Tree) NodeListOrdered(order TraversalOrder) []*Node {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	switch order {
+	case PreOrder, InOrder:
+		return t.Root.orderedList(order, make([]*Node, 0, t.count))
+	default:
+		return t.Root.nodeListInto(make([]*Node, 0, t.count))
+	}
+}
+
+// Appends all nodes in the (sub)tree to buf in PreOrder or InOrder, returning the extended slice.
+func (n *Node) orderedList(order TraversalOrder, buf []*Node) []*Node {
+	if n == nil {
+		return buf
+	}
+	if order == InOrder {
+		buf = n.leftChild.orderedList(order, buf)
+	}
+	buf = append(buf, n)
+	buf = append(buf, n.bucket...)
+	if order == PreOrder {
+		buf = n.leftChild.orderedList(order, buf)
+	}
+	return n.rightChild.orderedList(order, buf)
+}
+
+// Returns a slice of the leaves of the tree: every node with no children, including any nodes
+// in buckets. Leaves are listed in the same order as NodeList lists them.
+func (t *Tree) Leaves() []*Node {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	var leaves []*Node
+	t.Root.traverse(func(n *Node) {
+		if n.leftChild == nil && n.rightChild == nil {
+			leaves = append(leaves, n)
+		}
+	})
+	return leaves
+}
+
+// Returns k nodes chosen uniformly at random from the tree, without repeats, or every node if the
+// tree has k nodes or fewer. The nodes are chosen by reservoir sampling in a single traversal, so
+// only k nodes are held at once rather than a list of the whole tree. They're listed in no
+// particular order. Returns nil if k <= 0. Random numbers come from math/rand's shared source.
+func (t *Tree) Sample(k int) []*Node {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	if k <= 0 || t.Root == nil {
+		return nil
+	}
+	if k > t.count {
+		k = t.count
+	}
+	sample := make([]*Node, 0, k)
+	seen := 0
+	t.Root.traverse(func(n *Node) {
+		seen++
+		if len(sample) < k {
+			sample = append(sample, n)
+		} else if i := rand.Intn(seen); i < k {
+			sample[i] = n
+		}
+	})
+	return sample
 }
 
 // Returns a slice of all distinct nodes in the tree. This is done by a tree traversal,
 // and will be equally slow.
 func (n *Node) nodeList() []*Node {
-	nodelist := make([]*Node, 0, 100)
+	return n.nodeListInto(make([]*Node, 0, 100))
+}
+
+// Appends all distinct nodes in the (sub)tree to buf, returning the extended slice.
+func (n *Node) nodeListInto(buf []*Node) []*Node {
 	f := func(n *Node) {
-		nodelist = append(nodelist, n)
+		buf = append(buf, n)
 	}
 	n.traverse(f)
 
-	return nodelist
+	return buf
 }
 
 // Wrapper for a slice of nodes implementing sort.Interface for different dimensional axes.
@@ -52,14 +172,117 @@ func (snl *sortableNodeList) Len() int {
 	return len(snl.Nodes)
 }
 
+// Orders nodes on Axis, breaking ties on each following axis in turn, wrapping around to the first,
+// then on Index, so lists holding the same nodes always sort into the same order whatever order
+// they start in. Only nodes with the same coordinates and Index are left unordered.
 func (snl *sortableNodeList) Less(i, j int) bool {
-	return snl.Nodes[i].Coordinates[snl.Axis] < snl.Nodes[j].Coordinates[snl.Axis]
+	return snl.less(snl.Nodes[i], snl.Nodes[j])
+}
+
+// Tests whether node a comes before node b in the order described by Less.
+func (snl *sortableNodeList) less(a, b *Node) bool {
+	dimensions := len(a.Coordinates)
+	for i := 0; i < dimensions; i++ {
+		axis := (snl.Axis + i) % dimensions
+		if a.Coordinates[axis] != b.Coordinates[axis] {
+			return a.Coordinates[axis] < b.Coordinates[axis]
+		}
+	}
+	return a.Index < b.Index
 }
 
 func (snl *sortableNodeList) Swap(i, j int) {
 	snl.Nodes[i], snl.Nodes[j] = snl.Nodes[j], snl.Nodes[i]
 }
 
+// Rearranges the list around the node that would be at index k if it were sorted on Axis, so that
+// every node before it is less on Axis and every node after it is greater or equal, and returns its
+// new index. This is the first index holding that node's value on Axis, so it's less than k if
+// nodes before k share the value. Of the nodes sharing the value, the one first in the order
+// described by Less is moved to that index, so the same nodes always give the same result.
+// keys is scratch space for the nodes' values on Axis, and is reallocated if it's too short.
+func (snl *sortableNodeList) selectNth(k int, keys []float64) int {
+	if len(keys) < len(snl.Nodes) {
+		keys = make([]float64, len(snl.Nodes))
+	}
+	keys = keys[:len(snl.Nodes)]
+	for i, n := range snl.Nodes {
+		keys[i] = n.Coordinates[snl.Axis]
+	}
+	return snl.leastFrom(selectKeyed(keys, snl.Nodes, k))
+}
+
+// Rearranges keys around the one that would be at index k if they were sorted, moving each of items
+// along with the key at the same index, so that every key before it is less and every key after it
+// is greater or equal, and returns its new index, the first holding its value. Tree and TypedTree
+// both choose their medians with it, copying each node's coordinate on the split axis into keys
+// first, so the partition loop compares values in a flat list rather than reading them through nodes.
+//
+// This is a quickselect using a three-way partition around a median of three pivot, so it takes
+// O(n) time on average, and lists with many equal values don't slow it down.
+func selectKeyed[T Numeric, E any](keys []T, items []E, k int) int {
+	lo, hi := 0, len(keys)-1
+	swap := func(i, j int) {
+		keys[i], keys[j] = keys[j], keys[i]
+		items[i], items[j] = items[j], items[i]
+	}
+	for lo < hi {
+		// order keys at lo, mid and hi, so the median of the three is at mid
+		mid := lo + (hi-lo)/2
+		if keys[mid] < keys[lo] {
+			swap(mid, lo)
+		}
+		if keys[hi] < keys[lo] {
+			swap(hi, lo)
+		}
+		if keys[hi] < keys[mid] {
+			swap(hi, mid)
+		}
+		pivot := keys[mid]
+
+		// partition into [lo, lt) < pivot, [lt, gt] == pivot and (gt, hi] > pivot
+		lt, i, gt := lo, lo, hi
+		for i <= gt {
+			switch v := keys[i]; {
+			case v < pivot:
+				swap(lt, i)
+				lt++
+				i++
+			case v > pivot:
+				swap(i, gt)
+				gt--
+			default:
+				i++
+			}
+		}
+
+		switch {
+		case k < lt:
+			hi = lt - 1
+		case k > gt:
+			lo = gt + 1
+		default:
+			return lt
+		}
+	}
+	// everything before lo is less than the remaining key
+	return lo
+}
+
+// Swaps the node first in the order described by Less, out of those from index i on sharing node
+// i's value on Axis, into index i, and returns i. Every node before i must be less on Axis.
+func (snl *sortableNodeList) leastFrom(i int) int {
+	nodes, axis := snl.Nodes, snl.Axis
+	least := i
+	for j := i + 1; j < len(nodes); j++ {
+		if nodes[j].Coordinates[axis] == nodes[i].Coordinates[axis] && snl.less(nodes[j], nodes[least]) {
+			least = j
+		}
+	}
+	nodes[i], nodes[least] = nodes[least], nodes[i]
+	return i
+}
+
 // Perform the same search as Node.FindRange() on a list of nodes, used in
 // unit testing. Axis is ignored in this function.
 func (snl *sortableNodeList) findrange(ranges map[int]Range) ([]*Node, error) {
@@ -68,7 +291,7 @@ func (snl *sortableNodeList) findrange(ranges map[int]Range) ([]*Node, error) {
 		add := true
 		for a, r := range ranges {
 			if a >= len(n.Coordinates) {
-				return nil, errors.New("Range on axis " + string(a) + " exceeds tree dimensions.")
+				return nil, errors.New("Range on axis " + strconv.Itoa(a) + " exceeds tree dimensions.")
 			}
 			if a < 0 {
 				return nil, errors.New("Negative axes are invalid.")
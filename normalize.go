@@ -0,0 +1,93 @@
+// Copyright 2012 by Graeme Humphries <graeme@sudo.ca>
+//
+// kdtree is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// kdtree is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with kdtree.  If not, see http://www.gnu.org/licenses/.
+
+package kdtree
+
+/***** Coordinate Normalization Functions *****/
+
+// Rescales every node's coordinates in place so that each axis runs from 0 to 1, then rebuilds the
+// Tree, so that axes with large scales don't dominate distances. Returns the original minimum and
+// maximum of each axis, as bounds[axis] = {min, max}, which NormalizePoint uses to transform query
+// points in the same way, and DenormalizePoint uses to transform results back. An axis on which
+// every node has the same coordinate is rescaled to 0. Coordinates must be finite.
+// Returns nil if the tree is empty.
+func (t *Tree) NormalizeCoordinates() [][2]float64 {
+	t.Mutex.Lock()
+	defer t.Mutex.Unlock()
+	if t.Root == nil {
+		return nil
+	}
+
+	nodes := t.Root.nodeListInto(make([]*Node, 0, t.count))
+	bounds := make([][2]float64, len(t.Root.Coordinates))
+	for a, c := range t.Root.Coordinates {
+		bounds[a] = [2]float64{c, c}
+	}
+	for _, n := range nodes {
+		for a, c := range n.Coordinates {
+			if c < bounds[a][0] {
+				bounds[a][0] = c
+			} else if c > bounds[a][1] {
+				bounds[a][1] = c
+			}
+		}
+	}
+	for _, n := range nodes {
+		for a, c := range n.Coordinates {
+			n.Coordinates[a] = normalize(c, bounds[a])
+		}
+	}
+
+	// rescaling keeps coordinates in order, but rounding can make distinct ones equal, which would
+	// leave nodes equal to a split in its left subtree
+	t.Root = buildRootNode(nodes, 0, nil, t.build)
+	return bounds
+}
+
+// Returns a copy of coords transformed in the same way NormalizeCoordinates transformed the nodes
+// of the tree it returned bounds for. Returns (nil, ErrDimensionMismatch) if coords doesn't have
+// one coordinate for each axis in bounds.
+func NormalizePoint(coords []float64, bounds [][2]float64) ([]float64, error) {
+	if len(coords) != len(bounds) {
+		return nil, ErrDimensionMismatch
+	}
+	result := make([]float64, len(coords))
+	for a, c := range coords {
+		result[a] = normalize(c, bounds[a])
+	}
+	return result, nil
+}
+
+// Returns a copy of normalized coords transformed back to the original scale of the tree that
+// NormalizeCoordinates returned bounds for, undoing NormalizePoint. Returns (nil, ErrDimensionMismatch)
+// if coords doesn't have one coordinate for each axis in bounds.
+func DenormalizePoint(coords []float64, bounds [][2]float64) ([]float64, error) {
+	if len(coords) != len(bounds) {
+		return nil, ErrDimensionMismatch
+	}
+	result := make([]float64, len(coords))
+	for a, c := range coords {
+		result[a] = bounds[a][0] + c*(bounds[a][1]-bounds[a][0])
+	}
+	return result, nil
+}
+
+// Rescales c from the range bounds = {min, max} to the range 0 to 1, or to 0 if min == max.
+func normalize(c float64, bounds [2]float64) float64 {
+	if bounds[1] == bounds[0] {
+		return 0
+	}
+	return (c - bounds[0]) / (bounds[1] - bounds[0])
+}
@@ -0,0 +1,83 @@
+// Copyright 2012 by Graeme Humphries <graeme@sudo.ca>
+//
+// kdtree is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// kdtree is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with kdtree.  If not, see http://www.gnu.org/licenses/.
+
+package kdtree
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNormalizeCoordinates(t *testing.T) {
+	nl := genlist(3, 2000)
+	original := make([][]float64, len(nl))
+	for i, n := range nl {
+		n.Coordinates[1] = n.Coordinates[1]*1000 - 500
+		n.Coordinates[2] = 7 // every node has the same coordinate on axis 2
+		original[i] = append([]float64(nil), n.Coordinates...)
+	}
+	tree := BuildTree(nl)
+
+	bounds := tree.NormalizeCoordinates()
+	if len(bounds) != 3 || bounds[1][0] < -500 || bounds[1][1] > 500 || bounds[2] != [2]float64{7, 7} {
+		t.Fatal("NormalizeCoordinates returned unexpected bounds", bounds)
+	}
+	if err := tree.Validate(); err != nil {
+		t.Fatal("Tree is not valid: " + err.Error())
+	}
+	for i, n := range nl {
+		for a, c := range n.Coordinates {
+			if c < 0 || c > 1 || (a == 2 && c != 0) {
+				t.Fatal(n.String() + " has a coordinate outside of 0 to 1")
+			}
+		}
+		if search, err := tree.Find(n.Coordinates); err != nil || search != n {
+			t.Fatal(n.String() + " not found after normalizing!")
+		}
+
+		normalized, err := NormalizePoint(original[i], bounds)
+		if err != nil || !equal_fl(normalized, n.Coordinates) {
+			t.Fatal("NormalizePoint transformed", original[i], "to", normalized, "expected", n.Coordinates)
+		}
+		restored, err := DenormalizePoint(n.Coordinates, bounds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for a := range restored {
+			if math.Abs(restored[a]-original[i][a]) > 1e-9 {
+				t.Fatal("DenormalizePoint transformed", n.Coordinates, "to", restored, "expected", original[i])
+			}
+		}
+	}
+
+	// searches in the normalized space should find the same nodes as a scan of it
+	for i := 0; i < 100; i++ {
+		coords, _ := NormalizePoint([]float64{0.5, float64(i*10 - 500), 7}, bounds)
+		n, dist, err := tree.NearestNeighbor(coords)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, expectedDist := bruteNearest(nl, coords, EuclideanMetric{}); n != expected && dist != expectedDist {
+			t.Fatal("Nearest to " + String(coords) + " should be " + expected.String() + ", found " + n.String())
+		}
+	}
+
+	if _, err := NormalizePoint([]float64{1, 2}, bounds); err != ErrDimensionMismatch {
+		t.Fatal("Normalizing a point with the wrong dimensions should return ErrDimensionMismatch, got", err)
+	}
+	if bounds := new(Tree).NormalizeCoordinates(); bounds != nil {
+		t.Fatal("Normalizing an empty tree should return nil bounds, got", bounds)
+	}
+}
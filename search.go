@@ -16,24 +16,40 @@
 package kdtree
 
 import (
+	"context"
 	"errors"
+	"math"
+	"strconv"
 )
 
 /***** Tree Search Functions *****/
 
 // Searches Tree for node at exact coords. Returns (nil, nil) if no node matching coords found,
-// or (nil, error) if len(coords) != tree dimensions.
-func (t *Tree) Find(coords [4]float64) (*Node, error) {
+// or (nil, error) if len(coords) != tree dimensions. A tree may hold several nodes with the same
+// coordinates, in which case any one of them is returned; use FindAll to get every one.
+// Coordinates are compared with ==, so coords containing NaN never match; see ErrNaNCoordinate.
+func (t *Tree) Find(coords []float64) (*Node, error) {
 	t.Mutex.RLock()
 	defer t.Mutex.RUnlock()
-	return t.Root.find(coords)
+	if t.Root == nil {
+		return nil, nil
+	}
+	n, err := t.Root.find(coords)
+	t.touch(n)
+	return n, err
 }
 
 // Searches (sub)tree for node at exact coords. Returns (nil, nil) if no node matching coords found,
 // or (nil, error) if len(coords) != tree dimensions.
-func (n *Node) find(coords [4]float64) (*Node, error) {
-	if len(coords) != len(n.Coordinates) {
-		return nil, errors.New("Search coordinates have " + string(len(coords)) + " dimensions, tree has " + string(len(n.Coordinates)) + " dimensions.")
+func (n *Node) find(coords []float64) (*Node, error) {
+	if err := n.checkDimensions(coords); err != nil {
+		return nil, err
+	}
+
+	for _, b := range n.bucket {
+		if equal_fl(coords, b.Coordinates) {
+			return b, nil
+		}
 	}
 
 	axis := n.axis
@@ -56,47 +72,329 @@ func (n *Node) find(coords [4]float64) (*Node, error) {
 	return n.rightChild.find(coords)
 }
 
-// Range parameter, used to search the k-d tree.
+// Searches Tree for all nodes at exact coords, such as duplicate points that were added separately.
+// Returns (nil, nil) if no node matching coords found, or (nil, error) if len(coords) != tree dimensions.
+func (t *Tree) FindAll(coords []float64) ([]*Node, error) {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	if t.Root == nil {
+		return nil, nil
+	}
+	if err := t.Root.checkDimensions(coords); err != nil {
+		return nil, err
+	}
+	result := t.Root.findAll(coords, nil)
+	for _, n := range result {
+		t.touch(n)
+	}
+	return result, nil
+}
+
+// Appends all nodes in (sub)tree at exact coords to result. Nodes with the same value as the
+// split on an axis are always in the right subtree, so duplicates are found by carrying on
+// searching to the right after a match.
+func (n *Node) findAll(coords []float64, result []*Node) []*Node {
+	if n == nil {
+		return result
+	}
+	for _, b := range n.bucket {
+		if equal_fl(coords, b.Coordinates) {
+			result = append(result, b)
+		}
+	}
+	if coords[n.axis] < n.Coordinates[n.axis] {
+		return n.leftChild.findAll(coords, result)
+	}
+	if equal_fl(coords, n.Coordinates) {
+		result = append(result, n)
+	}
+	return n.rightChild.findAll(coords, result)
+}
+
+// Returns the node in the Tree with the minimum coordinate on axis. Where a node splits on axis,
+// its right subtree is skipped, so this visits far fewer nodes than a full scan.
+// Returns (nil, nil) if the tree is empty, or (nil, error) if axis is outside of the tree's dimensions.
+func (t *Tree) FindMin(axis int) (*Node, error) {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	if t.Root == nil {
+		return nil, nil
+	}
+	if err := checkAxis(axis, len(t.Root.Coordinates)); err != nil {
+		return nil, err
+	}
+	return t.Root.findMin(axis), nil
+}
+
+// Returns the node in the Tree with the maximum coordinate on axis. Where a node splits on axis,
+// its left subtree is skipped, so this visits far fewer nodes than a full scan.
+// Returns (nil, nil) if the tree is empty, or (nil, error) if axis is outside of the tree's dimensions.
+func (t *Tree) FindMax(axis int) (*Node, error) {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	if t.Root == nil {
+		return nil, nil
+	}
+	if err := checkAxis(axis, len(t.Root.Coordinates)); err != nil {
+		return nil, err
+	}
+	return t.Root.findMax(axis), nil
+}
+
+// Returns the node in the Tree whose coordinate on axis is closest to value, ignoring its other
+// coordinates, such as for one dimensional queries on a multi-dimensional tree. Where a node splits
+// on axis, the side of it away from value is skipped unless the split is closer than the best node
+// found so far, so this visits far fewer nodes than a full scan.
+// Returns (nil, nil) if the tree is empty, or (nil, error) if axis is outside of the tree's dimensions.
+func (t *Tree) NearestOnAxis(axis int, value float64) (*Node, error) {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	if t.Root == nil {
+		return nil, nil
+	}
+	if err := checkAxis(axis, len(t.Root.Coordinates)); err != nil {
+		return nil, err
+	}
+	best, _ := t.Root.nearestOnAxis(axis, value, nil, math.Inf(1))
+	return best, nil
+}
+
+// Searches (sub)tree for a node whose coordinate on axis is closer to value than best, which is
+// bestDist away, returning the closest node found and its distance.
+func (n *Node) nearestOnAxis(axis int, value float64, best *Node, bestDist float64) (*Node, float64) {
+	if n == nil {
+		return best, bestDist
+	}
+	if d := math.Abs(n.Coordinates[axis] - value); d < bestDist {
+		best, bestDist = n, d
+	}
+	for _, b := range n.bucket {
+		if d := math.Abs(b.Coordinates[axis] - value); d < bestDist {
+			best, bestDist = b, d
+		}
+	}
+	if n.axis != axis {
+		best, bestDist = n.leftChild.nearestOnAxis(axis, value, best, bestDist)
+		return n.rightChild.nearestOnAxis(axis, value, best, bestDist)
+	}
+
+	// every node on the far side of the split is at least as far away as the split itself
+	split := n.Coordinates[axis]
+	near, far := n.leftChild, n.rightChild
+	if value >= split {
+		near, far = far, near
+	}
+	best, bestDist = near.nearestOnAxis(axis, value, best, bestDist)
+	if math.Abs(split-value) < bestDist {
+		best, bestDist = far.nearestOnAxis(axis, value, best, bestDist)
+	}
+	return best, bestDist
+}
+
+// Returns the node in this (sub)tree with the minimum coordinate on axis. Where this node splits
+// on axis, only its left subtree can hold anything smaller, so the right subtree is skipped.
+// Nodes in a bucket may be on either side of the split, so they're always checked.
+func (n *Node) findMin(axis int) *Node {
+	if n == nil {
+		return nil
+	}
+	min := n
+	for _, b := range n.bucket {
+		if b.Coordinates[axis] < min.Coordinates[axis] {
+			min = b
+		}
+	}
+	children := []*Node{n.leftChild, n.rightChild}
+	if n.axis == axis {
+		children = children[:1]
+	}
+	for _, child := range children {
+		if m := child.findMin(axis); m != nil && m.Coordinates[axis] < min.Coordinates[axis] {
+			min = m
+		}
+	}
+	return min
+}
+
+// Returns the node in this (sub)tree with the maximum coordinate on axis. Where this node splits
+// on axis, everything in its right subtree is at least as large, so the left subtree is skipped.
+// Nodes in a bucket may be on either side of the split, so they're always checked.
+func (n *Node) findMax(axis int) *Node {
+	if n == nil {
+		return nil
+	}
+	max := n
+	for _, b := range n.bucket {
+		if b.Coordinates[axis] > max.Coordinates[axis] {
+			max = b
+		}
+	}
+	children := []*Node{n.leftChild, n.rightChild}
+	if n.axis == axis {
+		children = children[1:]
+	}
+	for _, child := range children {
+		if m := child.findMax(axis); m != nil && m.Coordinates[axis] > max.Coordinates[axis] {
+			max = m
+		}
+	}
+	return max
+}
+
+// Range parameter, used to search the k-d tree. Either bound may be infinite: {math.Inf(-1), 5}
+// matches every coordinate <= 5, and a Range whose bounds are both infinite matches every coordinate,
+// whichever way around they are.
 type Range struct {
 	Min float64
 	Max float64
 }
 
+// Returns a Range from min to max, swapping them if min > max, so the Range always matches the
+// coordinates between them. Returns an error if either bound is NaN, as no coordinate compares
+// with NaN and the Range would match nothing.
+func NewRange(min, max float64) (Range, error) {
+	if math.IsNaN(min) || math.IsNaN(max) {
+		return Range{}, errors.New("Range bounds can't be NaN.")
+	}
+	if min > max {
+		min, max = max, min
+	}
+	return Range{min, max}, nil
+}
+
+// Tests whether both of the Range's bounds are infinite, in opposite directions, so it matches
+// every coordinate.
+func (r Range) unbounded() bool {
+	return TypedRange[float64](r).unbounded()
+}
+
+// Tests whether coordinate c is within the Range. Max is only inclusive if maxInclusive is true,
+// or if it's +Inf, as nothing is beyond it.
+func (r Range) contains(c float64, maxInclusive bool) bool {
+	return TypedRange[float64](r).contains(c, maxInclusive)
+}
+
+// Tests whether the Range can hold coordinates less than split, so the left subtree of a node
+// splitting at split must be searched.
+func (r Range) reachesLeft(split float64) bool {
+	return TypedRange[float64](r).reachesLeft(split)
+}
+
+// Tests whether the Range can hold coordinates >= split, so the right subtree of a node splitting
+// at split must be searched.
+func (r Range) reachesRight(split float64, maxInclusive bool) bool {
+	return TypedRange[float64](r).reachesRight(split, maxInclusive)
+}
+
 // Find a list of Nodes in Tree matching the supplied map of dimensional
 // Ranges. The map index is used as the axis to restrict. 
-// Use math.Inf() to create remove the restriction on Min or Max.
+// Use math.Inf() to remove the restriction on Min or Max.
 //
 // If no results are found, (nil, nil) is returned.
-// If an axis outside of the tree's dimensions is specified, nil is returned with an error.
+// If an axis outside of the tree's dimensions is specified, or a Range has a NaN bound, nil is
+// returned with an error. NewRange builds Ranges that are always valid.
 func (t *Tree) FindRange(ranges map[int]Range) ([]*Node, error) {
+	return t.FindRangeContext(context.Background(), ranges)
+}
+
+// Performs the same search as FindRange, but stops and returns (nil, ctx.Err()) as soon as
+// ctx is cancelled, rather than returning partial results.
+func (t *Tree) FindRangeContext(ctx context.Context, ranges map[int]Range) ([]*Node, error) {
 	t.Mutex.RLock()
 	defer t.Mutex.RUnlock()
-	return t.Root.findRange(ranges)
+	result, err := t.Root.findRange(ctx, ranges, true)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Performs the same search as FindRange, but stores the matching nodes in buf, reusing its memory
+// if it has enough capacity, so a loop running many range queries can recycle one buffer rather than
+// allocating a new list for each query. Any nodes already in buf are overwritten. The returned slice
+// shares buf's memory unless it had to grow, and is empty rather than nil if no nodes match, so it
+// can be passed back in as buf for the next query.
+//
+// If an axis outside of the tree's dimensions is specified, or a Range has a NaN bound, buf[:0] is
+// returned with an error.
+func (t *Tree) FindRangeInto(ranges map[int]Range, buf []*Node) ([]*Node, error) {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	buf = buf[:0]
+	if t.Root == nil {
+		return buf, nil
+	}
+	if err := checkRanges(ranges, len(t.Root.Coordinates)); err != nil {
+		return buf, err
+	}
+	return t.Root.appendRange(ranges, buf), nil
+}
+
+// Performs the same search as FindRange if inclusive is true. If inclusive is false, each Range
+// is half-open, matching coordinates >= Min and < Max, so adjacent ranges sharing an edge never
+// both match the same node.
+func (t *Tree) FindRangeBounds(ranges map[int]Range, inclusive bool) ([]*Node, error) {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	result, err := t.Root.findRange(context.Background(), ranges, inclusive)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Searches Tree for all nodes whose leading coordinates equal coords, whatever their remaining
+// coordinates, so a prefix of (x, y) finds every node with x on axis 0 and y on axis 1. This
+// performs the same search as FindRange with a Range of a single value on each of the first
+// len(coords) axes, and an empty coords matches every node.
+//
+// Returns (nil, nil) if no nodes match, or (nil, ErrDimensionMismatch) if coords has more
+// dimensions than the tree. As with Find, coords containing NaN never match.
+func (t *Tree) FindByPrefix(coords []float64) ([]*Node, error) {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	if t.Root == nil || hasNaN(coords) {
+		return nil, nil
+	}
+	if len(coords) > len(t.Root.Coordinates) {
+		return nil, ErrDimensionMismatch
+	}
+	ranges := make(map[int]Range, len(coords))
+	for a, c := range coords {
+		ranges[a] = Range{Min: c, Max: c}
+	}
+	result := t.Root.appendRange(ranges, nil)
+	if len(result) == 0 {
+		return nil, nil
+	}
+	return result, nil
 }
 
 // Find a list of nodes matching the supplied map of dimensional
 // Ranges. The map index is used as the axis to restrict. 
-// Use math.Inf() to create remove the restriction on Min or Max.
+// Use math.Inf() to remove the restriction on Min or Max.
+//
+// Max is inclusive if maxInclusive is true, otherwise only coordinates < Max match.
 //
 // If no results are found, (nil, nil) is returned.
-// If an axis outside of the tree's dimensions is specified, nil is returned with an error.
-func (n *Node) findRange(ranges map[int]Range) ([]*Node, error) {
+// If an axis outside of the tree's dimensions is specified, or ctx is cancelled, an error is returned.
+func (n *Node) findRange(ctx context.Context, ranges map[int]Range, maxInclusive bool) ([]*Node, error) {
 	if n == nil {
 		return nil, nil
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	result := make([]*Node, 0, 10)
 	// check to see if the current node should be returned
 	add := true
 	for a, r := range ranges {
-		if a >= len(n.Coordinates) {
-			return nil, errors.New("Range on axis " + string(a) + " exceeds tree dimensions.")
-		}
-		if a < 0 {
-			return nil, errors.New("Negative axes are invalid.")
+		if err := checkRange(a, r, len(n.Coordinates)); err != nil {
+			return nil, err
 		}
 
-		if n.Coordinates[a] < r.Min || n.Coordinates[a] > r.Max {
+		if !r.contains(n.Coordinates[a], maxInclusive) {
 			add = false
 			break
 		}
@@ -104,19 +402,25 @@ func (n *Node) findRange(ranges map[int]Range) ([]*Node, error) {
 	if add {
 		result = append(result, n)
 	}
+	for _, b := range n.bucket {
+		if b.inRangeBounds(ranges, maxInclusive) {
+			result = append(result, b)
+		}
+	}
 
 	// search subtrees
 	r, ok := ranges[n.axis]
 	// search subtree if we're not restricting this axis, or if restrictions match.
-	if !ok || r.Min < n.Coordinates[n.axis] {
-		if left, err := n.leftChild.findRange(ranges); err == nil {
+	if !ok || r.reachesLeft(n.Coordinates[n.axis]) {
+		if left, err := n.leftChild.findRange(ctx, ranges, maxInclusive); err == nil {
 			result = append(result, left...)
 		} else {
 			return result, err
 		}
 	}
-	if !ok || r.Max >= n.Coordinates[n.axis] {
-		if right, err := n.rightChild.findRange(ranges); err == nil {
+	// a half-open range ending at the split can't match anything in the right subtree
+	if !ok || r.reachesRight(n.Coordinates[n.axis], maxInclusive) {
+		if right, err := n.rightChild.findRange(ctx, ranges, maxInclusive); err == nil {
 			result = append(result, right...)
 		} else {
 			return result, err
@@ -126,8 +430,284 @@ func (n *Node) findRange(ranges map[int]Range) ([]*Node, error) {
 	return result, nil
 }
 
+// Appends the nodes in (sub)tree matching the supplied map of dimensional Ranges to result,
+// searching the same subtrees as findRange. Axes in ranges must already be checked.
+func (n *Node) appendRange(ranges map[int]Range, result []*Node) []*Node {
+	if n == nil {
+		return result
+	}
+
+	if n.inRanges(ranges) {
+		result = append(result, n)
+	}
+	for _, b := range n.bucket {
+		if b.inRanges(ranges) {
+			result = append(result, b)
+		}
+	}
+	r, ok := ranges[n.axis]
+	if !ok || r.reachesLeft(n.Coordinates[n.axis]) {
+		result = n.leftChild.appendRange(ranges, result)
+	}
+	if !ok || r.reachesRight(n.Coordinates[n.axis], true) {
+		result = n.rightChild.appendRange(ranges, result)
+	}
+	return result
+}
+
+// Performs the same search as FindRange, but sends each matching node on the returned node channel
+// as soon as it's found, rather than collecting them in a list. The node channel is closed once the
+// search is complete. If an axis outside of the tree's dimensions is specified, no nodes are sent and
+// the error is sent on the error channel, which is closed after the node channel.
+//
+// The Tree is read locked until the search is complete, so any change to the Tree blocks until the
+// node channel has been read until it's closed, or ctx is cancelled. A caller that stops reading early
+// must cancel ctx, which stops the search, closes the node channel and sends ctx.Err() on the error
+// channel, releasing the lock.
+func (t *Tree) FindRangeStream(ctx context.Context, ranges map[int]Range) (<-chan *Node, <-chan error) {
+	nodes := make(chan *Node)
+	errs := make(chan error, 1)
+	t.Mutex.RLock()
+	go func() {
+		defer t.Mutex.RUnlock()
+		defer close(errs)
+		defer close(nodes)
+		if t.Root == nil {
+			return
+		}
+		if err := checkRanges(ranges, len(t.Root.Coordinates)); err != nil {
+			errs <- err
+			return
+		}
+		if !t.Root.streamRange(ctx, ranges, nodes) {
+			errs <- ctx.Err()
+		}
+	}()
+	return nodes, errs
+}
+
+// Sends each node in this (sub)tree within ranges to result, returning false if ctx was cancelled
+// before they had all been sent.
+func (n *Node) streamRange(ctx context.Context, ranges map[int]Range, result chan<- *Node) bool {
+	if n == nil {
+		return true
+	}
+
+	if n.inRanges(ranges) && !sendNode(ctx, result, n) {
+		return false
+	}
+	for _, b := range n.bucket {
+		if b.inRanges(ranges) && !sendNode(ctx, result, b) {
+			return false
+		}
+	}
+	r, ok := ranges[n.axis]
+	if (!ok || r.reachesLeft(n.Coordinates[n.axis])) && !n.leftChild.streamRange(ctx, ranges, result) {
+		return false
+	}
+	if !ok || r.reachesRight(n.Coordinates[n.axis], true) {
+		return n.rightChild.streamRange(ctx, ranges, result)
+	}
+	return true
+}
+
+// Sends n to result, returning false without sending it if ctx is cancelled first.
+func sendNode(ctx context.Context, result chan<- *Node, n *Node) bool {
+	select {
+	case result <- n:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Count the Nodes in Tree matching the supplied map of dimensional Ranges, as FindRange would
+// return them, without building a list of the matching nodes.
+//
+// If an axis outside of the tree's dimensions is specified, 0 is returned with an error.
+func (t *Tree) CountRange(ranges map[int]Range) (int, error) {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	if t.Root == nil {
+		return 0, nil
+	}
+	if err := checkRanges(ranges, len(t.Root.Coordinates)); err != nil {
+		return 0, err
+	}
+	return t.Root.countRange(ranges), nil
+}
+
+// Count the nodes in (sub)tree matching the supplied map of dimensional Ranges,
+// searching the same subtrees as findRange. Axes in ranges must already be checked.
+func (n *Node) countRange(ranges map[int]Range) int {
+	if n == nil {
+		return 0
+	}
+
+	count := 0
+	if n.inRanges(ranges) {
+		count++
+	}
+	for _, b := range n.bucket {
+		if b.inRanges(ranges) {
+			count++
+		}
+	}
+	r, ok := ranges[n.axis]
+	if !ok || r.reachesLeft(n.Coordinates[n.axis]) {
+		count += n.leftChild.countRange(ranges)
+	}
+	if !ok || r.reachesRight(n.Coordinates[n.axis], true) {
+		count += n.rightChild.countRange(ranges)
+	}
+	return count
+}
+
+// Returns the extent of the Tree: for every axis, a Range from the minimum to the maximum coordinate
+// of any node on that axis. All axes are measured in one traversal of the tree.
+// Returns (nil, nil) for an empty tree, or (nil, ErrDimensionMismatch) if nodes have differing dimensions.
+func (t *Tree) Bounds() ([]Range, error) {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	if t.Root == nil {
+		return nil, nil
+	}
+
+	bounds := make([]Range, len(t.Root.Coordinates))
+	for i, c := range t.Root.Coordinates {
+		bounds[i] = Range{c, c}
+	}
+	var err error
+	t.Root.traverse(func(n *Node) {
+		if len(n.Coordinates) != len(bounds) {
+			err = ErrDimensionMismatch
+			return
+		}
+		for i, c := range n.Coordinates {
+			if c < bounds[i].Min {
+				bounds[i].Min = c
+			} else if c > bounds[i].Max {
+				bounds[i].Max = c
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bounds, nil
+}
+
+// Returns an error if any axis in ranges is outside of dimensions, or any Range has a NaN bound.
+func checkRanges(ranges map[int]Range, dimensions int) error {
+	for a, r := range ranges {
+		if err := checkRange(a, r, dimensions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns an error if axis is outside of dimensions, or r has a NaN bound.
+func checkRange(axis int, r Range, dimensions int) error {
+	return checkTypedRange(axis, TypedRange[float64](r), dimensions)
+}
+
+// Returns an error if axis is outside of dimensions.
+func checkAxis(axis, dimensions int) error {
+	if axis >= dimensions {
+		return errors.New("Axis " + strconv.Itoa(axis) + " exceeds tree dimensions.")
+	}
+	if axis < 0 {
+		return errors.New("Negative axes are invalid.")
+	}
+	return nil
+}
+
+// Tests whether this node's coordinates are within every one of ranges.
+func (n *Node) inRanges(ranges map[int]Range) bool {
+	return n.inRangeBounds(ranges, true)
+}
+
+// Tests whether this node's coordinates are within every one of ranges, with Max only inclusive
+// if maxInclusive is true.
+func (n *Node) inRangeBounds(ranges map[int]Range, maxInclusive bool) bool {
+	for a, r := range ranges {
+		if !r.contains(n.Coordinates[a], maxInclusive) {
+			return false
+		}
+	}
+	return true
+}
+
+// Find a list of Nodes in Tree within radius of coords, using the Tree's Metric.
+//
+// If no results are found, (nil, nil) is returned.
+// If len(coords) != tree dimensions, nil is returned with an error.
+func (t *Tree) FindWithinRadius(coords []float64, radius float64) ([]*Node, error) {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	if t.Root == nil {
+		return nil, nil
+	}
+	if err := t.Root.checkDimensions(coords); err != nil {
+		return nil, err
+	}
+	var result []*Node
+	t.Root.withinRadius(coords, t.metric(), radius, func(n *Node) {
+		result = append(result, n)
+	})
+	return result, nil
+}
+
+// Count the Nodes in Tree within radius of coords, as FindWithinRadius would return them, without
+// building a list of the matching nodes.
+//
+// If len(coords) != tree dimensions, 0 is returned with an error.
+func (t *Tree) CountWithinRadius(coords []float64, radius float64) (int, error) {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	if t.Root == nil {
+		return 0, nil
+	}
+	if err := t.Root.checkDimensions(coords); err != nil {
+		return 0, err
+	}
+	count := 0
+	t.Root.withinRadius(coords, t.metric(), radius, func(*Node) {
+		count++
+	})
+	return count, nil
+}
+
+// Calls visit for every node in (sub)tree within radius of coords. Subtrees are only searched
+// if coords is on their side of the splitting plane, or the plane is within radius of coords.
+func (n *Node) withinRadius(coords []float64, m Metric, radius float64, visit func(*Node)) {
+	if n == nil {
+		return
+	}
+
+	if m.Distance(coords, n.Coordinates) <= radius {
+		visit(n)
+	}
+	for _, b := range n.bucket {
+		if m.Distance(coords, b.Coordinates) <= radius {
+			visit(b)
+		}
+	}
+	// left subtree nodes are strictly less than the plane, so where distances grow on every axis an
+	// exact radius match can't be there. A WrappedMetric's plane distance may be measured the other
+	// way around, to the far side of the domain, which left subtree nodes can be at exactly.
+	plane := m.AxisDistance(coords[n.axis], n.Coordinates[n.axis], n.axis)
+	if coords[n.axis] < n.Coordinates[n.axis] || plane < radius || (plane == radius && !growsOnEveryAxis(m)) {
+		n.leftChild.withinRadius(coords, m, radius, visit)
+	}
+	if coords[n.axis] >= n.Coordinates[n.axis] || plane <= radius {
+		n.rightChild.withinRadius(coords, m, radius, visit)
+	}
+}
+
 // Tests equality of float slices, returns false if lengths or any values contained within differ.
-func equal_fl(a, b [4]float64) bool {
+func equal_fl(a, b []float64) bool {
 	if len(a) != len(b) {
 		return false
 	}
@@ -0,0 +1,68 @@
+// Copyright 2012 by Graeme Humphries <graeme@sudo.ca>
+//
+// kdtree is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// kdtree is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with kdtree.  If not, see http://www.gnu.org/licenses/.
+
+package kdtree
+
+import (
+	"context"
+)
+
+/***** Read-only Snapshots *****/
+
+// Immutable copy of a Tree, which can be searched from any number of goroutines without locking.
+// Changes to the Tree after the Snapshot was taken don't affect it. The nodes returned by searches
+// are the Snapshot's own copies of the Tree's nodes, sharing their Values.
+type Snapshot struct {
+	root   *Node
+	metric Metric
+	size   int
+}
+
+// Returns a Snapshot of the Tree's current state, for serving searches of a mostly static tree
+// without contending for the Tree's lock. The Tree is read locked while every node is copied,
+// so taking a Snapshot costs about as much as Clone.
+func (t *Tree) Snapshot() *Snapshot {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	return &Snapshot{root: t.Root.clone(nil), metric: t.metric(), size: t.count}
+}
+
+// Returns number of nodes in the Snapshot.
+func (s *Snapshot) Size() int {
+	return s.size
+}
+
+// Performs the same search as Tree.Find on the Snapshot.
+func (s *Snapshot) Find(coords []float64) (*Node, error) {
+	if s.root == nil {
+		return nil, nil
+	}
+	return s.root.find(coords)
+}
+
+// Performs the same search as Tree.KNearest on the Snapshot, using the Tree's Metric when
+// the Snapshot was taken.
+func (s *Snapshot) KNearest(coords []float64, k int) ([]*Node, error) {
+	return neighborNodes(kNearestNeighbors(s.root, s.metric, coords, k, false))
+}
+
+// Performs the same search as Tree.FindRange on the Snapshot.
+func (s *Snapshot) FindRange(ranges map[int]Range) ([]*Node, error) {
+	result, err := s.root.findRange(context.Background(), ranges, true)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
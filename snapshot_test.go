@@ -0,0 +1,76 @@
+// Copyright 2012 by Graeme Humphries <graeme@sudo.ca>
+//
+// kdtree is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// kdtree is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with kdtree.  If not, see http://www.gnu.org/licenses/.
+
+package kdtree
+
+import (
+	"testing"
+)
+
+// A Snapshot should give the same results as the Tree it was taken from, and keep giving them
+// after the Tree changes.
+func TestSnapshot(t *testing.T) {
+	nl := genlist(4, 10000)
+	tree := BuildTree(nl)
+	snapshot := tree.Snapshot()
+	queries := make([][]float64, 100)
+	for i := range queries {
+		queries[i] = rndCoords(4)
+	}
+	ranges := map[int]Range{0: Range{0.2, 0.4}, 3: Range{0.5, 0.9}}
+	expectedRange, _ := tree.FindRange(ranges)
+	expectedNearest := make([][]*Node, len(queries))
+	for i, coords := range queries {
+		expectedNearest[i], _ = tree.KNearest(coords, 5)
+	}
+
+	// change the tree, which shouldn't affect the snapshot
+	for _, n := range nl[:5000] {
+		if err := tree.Remove(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tree.AddAll(genlist(4, 5000))
+
+	if snapshot.Size() != len(nl) {
+		t.Fatal("Snapshot has", snapshot.Size(), "nodes, expected", len(nl))
+	}
+	for _, n := range nl {
+		if found, err := snapshot.Find(n.Coordinates); err != nil || found == nil {
+			t.Fatal(n.String(), "not found in snapshot!")
+		} else if found == n {
+			t.Fatal("Snapshot returned the tree's own node", n.String())
+		}
+	}
+	for i, coords := range queries {
+		results, err := snapshot.KNearest(coords, 5)
+		if err != nil {
+			t.Fatal("Error while searching snapshot:", err)
+		}
+		for j, n := range results {
+			if !equal_fl(n.Coordinates, expectedNearest[i][j].Coordinates) {
+				t.Fatal("Snapshot neighbor", j, "of", String(coords), "is", n.String(), "expected", expectedNearest[i][j].String())
+			}
+		}
+	}
+	if results, err := snapshot.FindRange(ranges); err != nil {
+		t.Fatal(err)
+	} else if len(results) != len(expectedRange) {
+		t.Fatal("Snapshot FindRange returned", len(results), "nodes, expected", len(expectedRange))
+	}
+	if _, err := snapshot.FindRange(map[int]Range{4: Range{0, 1}}); err == nil {
+		t.Fatal("Searching with an invalid axis should return an error.")
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2012 by Graeme Humphries <graeme@sudo.ca>
+//
+// kdtree is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// kdtree is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with kdtree.  If not, see http://www.gnu.org/licenses/.
+
+package kdtree
+
+/***** Axis Selection Strategies *****/
+
+// AxisStrategy chooses the axis a subtree built by BuildTreeStrategy splits on, given the nodes
+// in the subtree and its depth, with the root at depth 0. nodes is never empty, and must not be
+// modified or kept after the call returns.
+type AxisStrategy func(nodes []*Node, depth int) int
+
+// Splits on the axis along which nodes' coordinates have the greatest variance, which suits data
+// that is spread much further along some axes than others. Ties go to the lowest axis.
+func MaxVarianceAxis(nodes []*Node, depth int) int {
+	dimensions := len(nodes[0].Coordinates)
+	mean := make([]float64, dimensions)
+	for _, n := range nodes {
+		for a, c := range n.Coordinates {
+			mean[a] += c
+		}
+	}
+	for a := range mean {
+		mean[a] /= float64(len(nodes))
+	}
+
+	// the variances are all divided by len(nodes), so comparing sums of squares is enough
+	best, bestSum := 0, -1.0
+	for a := 0; a < dimensions; a++ {
+		sum := 0.0
+		for _, n := range nodes {
+			d := n.Coordinates[a] - mean[a]
+			sum += d * d
+		}
+		if sum > bestSum {
+			best, bestSum = a, sum
+		}
+	}
+	return best
+}
@@ -16,8 +16,13 @@
 package kdtree
 
 import (
+	"errors"
+	"math"
 	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"unsafe"
 )
 
 /***** Tree Object *****/
@@ -25,92 +30,1018 @@ import (
 type Tree struct {
 	Mutex sync.RWMutex
 
-	Root *Node
+	// Root should only be replaced through Tree methods, which keep count up to date.
+	Root  *Node
+	count int // number of nodes in the tree, returned by Size
+
+	build buildOptions // how the tree is shaped when it's rebuilt
+
+	// Distance metric for nearest neighbor and radius searches. If nil, whatever DefaultMetric is when
+	// the tree is searched is used.
+	Metric Metric
+
+	// If > 0, Add rebalances the whole tree when its depth exceeds AutoBalanceFactor * log2(size).
+	// A perfectly balanced tree has depth ceil(log2(size+1)), so values around 2 to 3 work well.
+	AutoBalanceFactor float64
+
+	// If between 0.5 and 1, Add and AddUnique keep the tree balanced as a scapegoat tree would: when a
+	// new node ends up deeper than log(size) / log(1/Alpha), the nearest of its ancestors with one
+	// subtree holding more than Alpha of its nodes is rebuilt, rather than the whole tree. Lower values
+	// keep the tree closer to balanced at the cost of rebuilding more often; 0.7 works well. Inserts
+	// then take amortized O(log n) time however nodes are added, unless many of them share coordinates,
+	// which can't be split apart.
+	Alpha float64
+
+	// If true, Find, FindAll, NearestNeighbor, NearestMatching and NearestExcluding record when they
+	// return each node, and Add, AddUnique, AddAll, Merge and Move record when each node was added, for
+	// Node.LastAccess and EvictOlderThan. Other searches don't record access. Trees made by BuildTree
+	// and LoadCSV start with TrackAccess unset, so their nodes have no access time until found.
+	TrackAccess bool
+
+	// Trees with more dimensions than this are searched by scanning every node, rather than the tree,
+	// by the NearestNeighbor and KNearest searches; DefaultLinearScanDimensions if 0, and never if < 0.
+	LinearScanDimensions int
 }
 
+// Returned when nodes in a tree, or being added to one, don't all have the same dimensions.
+var ErrDimensionMismatch = errors.New("Nodes have differing numbers of dimensions.")
+
+// Returned when a node being added to a tree, or coordinates a node is being moved to, contain NaN.
+// NaN compares unequal to everything, itself included, so a node with a NaN coordinate could never
+// be found again, and would be put on an arbitrary side of any split on that axis. Add, AddAll,
+// AddUnique and Move reject such nodes rather than losing them in the tree. BuildTree trusts its
+// nodes not to contain NaN; BuildTreeChecked checks them.
+var ErrNaNCoordinate = errors.New("Nodes can't have NaN coordinates.")
+
+// Returned by methods which need nodes' parent links, such as Add and Remove, when the Tree was
+// built by BuildTreeNoParent without them.
+var ErrNoParents = errors.New("Tree was built without parent links, so can't be changed node by node.")
+
+// Returned when a node passed to a Tree method, such as Remove, isn't in the Tree, because it's in
+// another tree or has already been removed. Changing the Tree through such a node would relink
+// nodes that aren't part of it.
+var ErrNodeNotInTree = errors.New("Node is not in this tree.")
+
 /***** Tree Functions *****/
 // These functions wrap the private Node functions in lock operations so that
 // they're thread-safe.
 
 
 // Performs a left depth first tree traversal, running function f on every Node found.
+// Nodes are visited in post-order: a node's left subtree, then its right subtree, then the node,
+// preceded by any nodes in its bucket.
+// The Tree is read locked during the traversal, so f must not modify the Tree. If f panics, the
+// lock is released as the panic unwinds through Traverse, so the Tree can still be used if the
+// panic is recovered.
 func (t *Tree) Traverse(f func(*Node)) {
-	t.Mutex.Lock()
-	defer t.Mutex.Unlock()
-	f(t.Root)
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	t.Root.traverse(f)
+}
+
+// Runs function f on every Node in the Tree in the same order as Traverse, along with the node's
+// depth, with the root at depth 0. Nodes in a bucket are one level deeper than the node holding
+// them, as if they were its children. Node.Axis gives the axis each node splits on.
+// The Tree is read locked during the traversal, so f must not modify the Tree.
+func (t *Tree) TraverseDepth(f func(n *Node, depth int)) {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	t.Root.traverseDepth(0, f)
+}
+
+// Runs function f on every Node in the Tree, in the same order as Traverse, until f returns false.
+// Unlike NodeList, this doesn't build a list of every node, so a search can stop early cheaply.
+// The Tree is read locked until Each returns or f panics, so f must not modify the Tree.
+func (t *Tree) Each(f func(*Node) bool) {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	t.Root.each(f)
+}
+
+// Runs function f on every node in this (sub)tree in post-order until f returns false.
+// Returns false if f stopped the iteration.
+func (n *Node) each(f func(*Node) bool) bool {
+	if n == nil {
+		return true
+	}
+	if !n.leftChild.each(f) || !n.rightChild.each(f) {
+		return false
+	}
+	for _, b := range n.bucket {
+		if !f(b) {
+			return false
+		}
+	}
+	return f(n)
 }
 
 /***** Tree Management Functions *****/
 
 // Builds a new tree from a list of nodes. This is destructive, and
 // will remove any existing tree membership from nodes passed to it.
+// All nodes must have the same dimensions, or BuildTree panics with ErrDimensionMismatch.
+// Ties between nodes sharing a coordinate are broken on their other coordinates, then their
+// Index, so the same nodes build the same tree whatever order they're listed in.
 func BuildTree(nodes []*Node) *Tree {
+	return buildTree(nodes, buildOptions{})
+}
+
+// Builds a new tree from a list of nodes as BuildTree does, but without linking nodes to their
+// parents, for trees that are searched far more often than they're changed. Searches, traversals
+// and whole-tree rebuilds such as AddAll, Merge, RemoveMatching and Balance work as usual, and keep
+// the tree without parent links. Add, AddUnique, Move, Detach and the Remove methods need parent
+// links to find their way back up the tree, so return ErrNoParents instead.
+//
+// Every Node still has its parent field, so this saves the work of maintaining the links while
+// building and rebuilding, but not their memory: the field takes 8 bytes a node on 64-bit platforms,
+// 8 GB for a billion nodes, whether or not it's set.
+func BuildTreeNoParent(nodes []*Node) *Tree {
+	return buildTree(nodes, buildOptions{noParents: true})
+}
+
+// Builds a new tree from a list of nodes as BuildTree does, but checks the nodes first, returning
+// an error describing the first invalid one rather than panicking part way through building. Every
+// node must be non-nil, with the same non-zero number of dimensions as the others, and no NaN coordinates.
+func BuildTreeChecked(nodes []*Node) (*Tree, error) {
+	for i, n := range nodes {
+		if n == nil {
+			return nil, errors.New("Node " + strconv.Itoa(i) + " is nil.")
+		}
+		if len(n.Coordinates) == 0 {
+			return nil, errors.New("Node " + strconv.Itoa(i) + " has no coordinates.")
+		}
+		if len(n.Coordinates) != len(nodes[0].Coordinates) {
+			return nil, errors.New("Node " + strconv.Itoa(i) + " has " + strconv.Itoa(len(n.Coordinates)) +
+				" dimensions, node 0 has " + strconv.Itoa(len(nodes[0].Coordinates)) + ".")
+		}
+		for a, c := range n.Coordinates {
+			if math.IsNaN(c) {
+				return nil, errors.New("Node " + strconv.Itoa(i) + " has a NaN coordinate on axis " + strconv.Itoa(a) + ".")
+			}
+		}
+	}
+	return BuildTree(nodes), nil
+}
+
+// Builds a new tree from a list of nodes as BuildTree does, but stops splitting once a subtree
+// has leafSize nodes or fewer. The first node of each such subtree becomes a leaf, holding the
+// rest in a bucket which is scanned linearly by searches. Larger buckets make a shallower tree,
+// trading a little extra distance checking for fewer pointer hops, and are later rebuilt with
+// the same leafSize when the tree is balanced. A leafSize of 1 or less builds the same tree as BuildTree.
+func BuildTreeBucket(nodes []*Node, leafSize int) *Tree {
+	return buildTree(nodes, buildOptions{leafSize: leafSize})
+}
+
+// Builds a new tree from a list of nodes as BuildTree does, but each node splits on the axis chosen
+// by strat from the nodes in its subtree, rather than cycling through the axes. The tree is later
+// rebuilt with the same strategy when it's balanced, and nodes added to it split on the axis after
+// their parent's, as in any other tree. strat is called from several goroutines for large lists,
+// so must be safe for concurrent use. A nil strat builds the same tree as BuildTree.
+// Panics if strat returns an axis outside of the nodes' dimensions.
+func BuildTreeStrategy(nodes []*Node, strat AxisStrategy) *Tree {
+	return buildTree(nodes, buildOptions{strategy: strat})
+}
+
+// Builds a new Tree from a copy of a list of nodes, shaped by opts.
+func buildTree(nodes []*Node, opts buildOptions) *Tree {
+	for _, n := range nodes {
+		if len(n.Coordinates) != len(nodes[0].Coordinates) {
+			panic(ErrDimensionMismatch)
+		}
+	}
+
 	tree := new(Tree)
 	tree.Mutex.Lock()
 	defer tree.Mutex.Unlock()
-	tree.Root = buildRootNode(nodes, 0, nil)
-//	f := func(n *Node) {
-//		n.tree = tree
-//	}
-//	tree.Root.traverse(f)
-
+	tree.build = opts
+	// buildRootNode reorders its list, so leave the caller's list as it was
+	tree.Root = buildRootNode(append([]*Node(nil), nodes...), 0, nil, opts)
+	tree.count = len(nodes)
 	return tree
 }
 
+// Subtrees built from more nodes than this have their left and right branches built in
+// parallel. Smaller subtrees are built sequentially, as the partitioning work saved is less than the
+// cost of starting a goroutine.
+var ParallelBuildThreshold = 2048
+
+// Options shaping the trees built by buildRootNode. The zero value builds the trees BuildTree does.
+type buildOptions struct {
+	leafSize  int          // most nodes in a leaf and its bucket, see BuildTreeBucket
+	strategy  AxisStrategy // chooses each node's axis, see BuildTreeStrategy, or nil to cycle through axes
+	noParents bool         // leave nodes' parents nil, see BuildTreeNoParent
+}
+
+// Returns the axis to split a subtree of nodes at depth on.
+func (o buildOptions) axis(nodes []*Node, depth int) int {
+	dimensions := len(nodes[0].Coordinates)
+	if o.strategy == nil {
+		return depth % dimensions
+	}
+	axis := o.strategy(nodes, depth)
+	if err := checkAxis(axis, dimensions); err != nil {
+		panic(err)
+	}
+	return axis
+}
+
 // Builds a tree from a list of nodes. Returns the root Node of the new tree.
 // This is destructive, and will break any existing tree these nodes may be a member of.
 // This is intended to be used to build an new tree, or as part of a tree Balance.
 // This is a recursive function, you should always call it with depth = 0, parent = nil.
-func buildRootNode(nodes []*Node, depth int, parent *Node) *Node {
+// Each node splits on the axis chosen by opts, and lists of opts.leafSize nodes or fewer become a
+// single leaf holding the rest of them in its bucket.
+//
+// nodes is partitioned in place, and each subtree is built from the part of it on one side of
+// the median, so building allocates nothing but one list of keys for choosing medians, and the
+// goroutines for parallel subtrees. The order of nodes is lost, so callers must pass a list they own.
+func buildRootNode(nodes []*Node, depth int, parent *Node, opts buildOptions) *Node {
+	return buildNode(nodes, make([]float64, len(nodes)), depth, parent, opts)
+}
+
+// Builds a (sub)tree for buildRootNode, using keys, at least as long as nodes, as scratch space for
+// choosing medians. Subtrees use the parts of keys matching their parts of nodes, so subtrees built
+// at the same time never share any.
+func buildNode(nodes []*Node, keys []float64, depth int, parent *Node, opts buildOptions) *Node {
+	if opts.noParents {
+		parent = nil
+	}
 	var root *Node
 	// special case handling first
-	switch len(nodes) {
-	case 0:
+	switch {
+	case len(nodes) == 0:
 		root = nil
-	case 1:
-		dimensions := len(nodes[0].Coordinates)
+	case len(nodes) == 1 || len(nodes) <= opts.leafSize:
+		// sorting makes the leaf and the order of its bucket the same whatever order nodes came in
+		snl := sortableNodeList{opts.axis(nodes, depth), nodes}
+		sort.Sort(&snl)
 		root = nodes[0]
 
-		root.axis = depth % dimensions
+		root.parent = parent
+		root.axis = snl.Axis
 		root.leftChild = nil
 		root.rightChild = nil
+		root.height = 1
+		root.bucket = nil
+		if len(nodes) > 1 {
+			root.bucket = append(make([]*Node, 0, len(nodes)-1), nodes[1:]...)
+			for _, b := range root.bucket {
+				b.parent = root
+				if opts.noParents {
+					b.parent = nil
+				}
+				b.axis = root.axis
+				b.leftChild, b.rightChild, b.bucket = nil, nil, nil
+				b.height = 1
+			}
+		}
 	default:
 		median := (len(nodes) / 2) - 1 // -1 so that it's a slice index
-		dimensions := len(nodes[0].Coordinates)
 
-		snl := new(sortableNodeList)
-		snl.Axis = depth % dimensions
-		snl.Nodes = make([]*Node, len(nodes))
-		copy(snl.Nodes, nodes)
-		sort.Sort(snl)
+		snl := sortableNodeList{opts.axis(nodes, depth), nodes}
+		// left subtrees only hold values less than the split, so the median moves down to the
+		// first node sharing its value, leaving any duplicates on the right.
+		median = snl.selectNth(median, keys)
+		left, right := nodes[:median], nodes[median+1:]
 
-		root = snl.Nodes[median]
+		root = nodes[median]
 
+		root.parent = parent
 		root.axis = snl.Axis
-		root.leftChild = buildRootNode(snl.Nodes[0:median], depth+1, root)
-		root.rightChild = buildRootNode(snl.Nodes[median+1:], depth+1, root)
+		root.bucket = nil
+		if len(nodes) > ParallelBuildThreshold {
+			// subtrees are built from separate parts of nodes, so they can safely be built at the same time
+			donechan := make(chan bool)
+			go func() {
+				root.leftChild = buildNode(left, keys[:median], depth+1, root, opts)
+				donechan <- true
+			}()
+			root.rightChild = buildNode(right, keys[median+1:], depth+1, root, opts)
+			<-donechan
+		} else {
+			root.leftChild = buildNode(left, keys[:median], depth+1, root, opts)
+			root.rightChild = buildNode(right, keys[median+1:], depth+1, root, opts)
+		}
+		root.setHeight()
 	}
 
 	return root
 }
 
+// Returns an independent deep copy of the Tree. Every node is copied, including its coordinates,
+// but Values are shared with the original nodes. The copy has the same settings as the Tree, such as
+// its Metric and AutoBalanceFactor, so it behaves in the same way.
+func (t *Tree) Clone() *Tree {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	clone := t.emptyCopy()
+	clone.Root = t.Root.clone(nil)
+	clone.count = t.count
+	if t.build.noParents {
+		clone.Root.traverse(func(n *Node) {
+			n.parent = nil
+		})
+	}
+	return clone
+}
+
+// Returns an empty Tree with the same settings as the Tree, and rebuilt in the same way, for Clone and
+// Detach.
+func (t *Tree) emptyCopy() *Tree {
+	return &Tree{
+		build:                t.build,
+		Metric:               t.Metric,
+		AutoBalanceFactor:    t.AutoBalanceFactor,
+		TrackAccess:          t.TrackAccess,
+		Alpha:                t.Alpha,
+		LinearScanDimensions: t.LinearScanDimensions,
+	}
+}
+
+// Returns a deep copy of this (sub)tree, linked to parent.
+func (n *Node) clone(parent *Node) *Node {
+	if n == nil {
+		return nil
+	}
+	c := NewNodeWithValue(append([]float64(nil), n.Coordinates...), n.Value)
+	c.Index = n.Index
+	// searches sharing the read lock may be recording an access at the same time
+	c.lastAccess = atomic.LoadInt64(&n.lastAccess)
+	c.axis = n.axis
+	c.height = n.height
+	c.parent = parent
+	c.leftChild = n.leftChild.clone(c)
+	c.rightChild = n.rightChild.clone(c)
+	for _, b := range n.bucket {
+		c.bucket = append(c.bucket, b.clone(c))
+	}
+	return c
+}
+
+// Removes every node from the Tree, leaving it empty and ready to be reused.
+func (t *Tree) Clear() {
+	t.Mutex.Lock()
+	defer t.Mutex.Unlock()
+	t.Root = nil
+	t.count = 0
+}
+
+// Adds node n to the Tree. If n is the root of a subtree, every node in the subtree is added
+// individually, and the subtree is broken up. Returns ErrDimensionMismatch if n doesn't have
+// the same dimensions as the Tree, or ErrNaNCoordinate if any of its coordinates are NaN.
+//
+// The Tree is write locked for the whole insertion, so concurrent calls to Add are safe, and
+// searches never see a partially linked node. If the Tree's Alpha or AutoBalanceFactor is set, Add
+// may also rebalance part or all of the tree.
+func (t *Tree) Add(n *Node) error {
+	t.Mutex.Lock()
+	defer t.Mutex.Unlock()
+	for _, nn := range n.nodeList() {
+		if err := t.insert(nn); err != nil {
+			return err
+		}
+	}
+	t.autoBalance()
+	return nil
+}
+
+// Adds node n to the Tree as Add does, unless a node with the same coordinates is already in the
+// Tree, in which case the Tree is left unchanged and that node is returned as existing, with added
+// false. This allows upserts, and keeps duplicates out of the Tree. Unlike Add, only n itself is
+// added, so n shouldn't be part of another tree. Returns ErrDimensionMismatch if n doesn't have the
+// same dimensions as the Tree, or ErrNaNCoordinate if any of its coordinates are NaN.
+func (t *Tree) AddUnique(n *Node) (added bool, existing *Node, err error) {
+	t.Mutex.Lock()
+	defer t.Mutex.Unlock()
+	if t.Root != nil {
+		if len(n.Coordinates) != len(t.Root.Coordinates) {
+			return false, nil, ErrDimensionMismatch
+		}
+		if existing, _ = t.Root.find(n.Coordinates); existing != nil {
+			return false, existing, nil
+		}
+	}
+	if err := t.insert(n); err != nil {
+		return false, nil, err
+	}
+	t.autoBalance()
+	return true, nil, nil
+}
+
+// Rebalances the whole Tree, which must already be locked, if its AutoBalanceFactor is set and
+// it has become too deep.
+func (t *Tree) autoBalance() {
+	if t.AutoBalanceFactor > 0 && float64(t.Root.height) > t.AutoBalanceFactor*math.Log2(float64(t.count)) {
+		t.Root = buildRootNode(t.Root.nodeListInto(make([]*Node, 0, t.count)), 0, nil, t.build)
+	}
+}
+
+// Adds a list of nodes to the Tree by rebuilding it from its existing nodes and the new ones in a
+// single pass, as BuildTree does. For n existing and m new nodes this costs about as much as building
+// a tree of n+m nodes, O((n+m) log(n+m)) comparisons, where m calls to Add cost O(m log n) while the
+// tree stays balanced but up to O(m(n+m)) as repeated inserts into one region make it degenerate.
+// The result is also balanced. Returns ErrDimensionMismatch without changing the Tree if any node
+// doesn't have the same dimensions as the Tree, or ErrNaNCoordinate if any node has a NaN coordinate.
+func (t *Tree) AddAll(nodes []*Node) error {
+	t.Mutex.Lock()
+	defer t.Mutex.Unlock()
+	if len(nodes) == 0 {
+		return nil
+	}
+	dimensions := len(nodes[0].Coordinates)
+	if t.Root != nil {
+		dimensions = len(t.Root.Coordinates)
+	}
+	for _, n := range nodes {
+		if len(n.Coordinates) != dimensions {
+			return ErrDimensionMismatch
+		}
+		if hasNaN(n.Coordinates) {
+			return ErrNaNCoordinate
+		}
+	}
+	for _, n := range nodes {
+		t.touch(n)
+	}
+	t.Root = buildRootNode(append(t.Root.nodeListInto(make([]*Node, 0, t.count+len(nodes))), nodes...), 0, nil, t.build)
+	t.count += len(nodes)
+	return nil
+}
+
+// Inserts node n into the Tree as a new leaf. The Tree must already be locked.
+func (t *Tree) insert(n *Node) error {
+	if t.build.noParents {
+		return ErrNoParents
+	}
+	if hasNaN(n.Coordinates) {
+		return ErrNaNCoordinate
+	}
+	if t.Root == nil {
+		n.axis, n.height = 0, 1
+		n.parent, n.leftChild, n.rightChild, n.bucket = nil, nil, nil, nil
+		t.touch(n)
+		t.Root = n
+		t.count = 1
+		return nil
+	}
+	if len(n.Coordinates) != len(t.Root.Coordinates) {
+		return ErrDimensionMismatch
+	}
+
+	parent, depth := t.Root, 1
+	for ; ; depth++ {
+		if n.Coordinates[parent.axis] < parent.Coordinates[parent.axis] {
+			if parent.leftChild == nil {
+				parent.leftChild = n
+				break
+			}
+			parent = parent.leftChild
+		} else {
+			if parent.rightChild == nil {
+				parent.rightChild = n
+				break
+			}
+			parent = parent.rightChild
+		}
+	}
+	n.axis, n.height = (parent.axis+1)%len(n.Coordinates), 1
+	n.parent, n.leftChild, n.rightChild, n.bucket = parent, nil, nil, nil
+	t.touch(n)
+	parent.updateHeights()
+	t.count++
+	t.rebuildScapegoat(n, depth)
+	return nil
+}
+
+// Rebuilds the subtree of the nearest ancestor of n, which was just inserted depth levels below the
+// root, that's unbalanced by the Tree's Alpha, if n is too deep. The Tree must already be locked.
+func (t *Tree) rebuildScapegoat(n *Node, depth int) {
+	if t.Alpha <= 0.5 || t.Alpha >= 1 || float64(depth) <= math.Log(float64(t.count))/math.Log(1/t.Alpha) {
+		return
+	}
+	// sizes are counted on the way up, so only the subtrees beside n's path are walked
+	size := 1
+	for child, p := n, n.parent; p != nil; child, p = p, p.parent {
+		sibling := p.leftChild
+		if sibling == child {
+			sibling = p.rightChild
+		}
+		total := size + sibling.size() + 1 + len(p.bucket)
+		if float64(size) > t.Alpha*float64(total) {
+			t.rebuild(p)
+			return
+		}
+		size = total
+	}
+}
+
+// Tests whether any of coords are NaN.
+func hasNaN(coords []float64) bool {
+	for _, c := range coords {
+		if math.IsNaN(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// Moves every node from other into the Tree, leaving other empty. The combined nodes are rebuilt
+// into a balanced tree in one pass, which is cheaper than adding them one at a time.
+// Returns ErrDimensionMismatch if the trees have different dimensions, in which case neither is changed.
+func (t *Tree) Merge(other *Tree) error {
+	if t == other {
+		return errors.New("Can't merge a tree with itself.")
+	}
+	first, second := lockOrder(t, other)
+	first.Mutex.Lock()
+	defer first.Mutex.Unlock()
+	second.Mutex.Lock()
+	defer second.Mutex.Unlock()
+
+	if other.Root == nil {
+		return nil
+	}
+	if t.Root != nil && len(t.Root.Coordinates) != len(other.Root.Coordinates) {
+		return ErrDimensionMismatch
+	}
+	nodes := other.Root.nodeListInto(t.Root.nodeListInto(make([]*Node, 0, t.count+other.count)))
+	for _, n := range nodes[t.count:] {
+		t.touch(n)
+	}
+	t.Root = buildRootNode(nodes, 0, nil, t.build)
+	t.count += other.count
+	other.Root = nil
+	other.count = 0
+	return nil
+}
+
+// Returns trees a and b in the order they must be locked in when both are locked at once. The tree
+// at the lower address is always locked first, so two goroutines locking the same trees in opposite
+// directions can't deadlock.
+func lockOrder(a, b *Tree) (first, second *Tree) {
+	if uintptr(unsafe.Pointer(b)) < uintptr(unsafe.Pointer(a)) {
+		return b, a
+	}
+	return a, b
+}
+
+// Moves node n in the Tree to newCoords, keeping its Index and Value. If n is a leaf, or
+// keeps the same coordinate on its own axis, and newCoords are on the same side of all of its
+// ancestors' splitting planes, n is updated in place. Otherwise it is removed and added again.
+// Returns an error if newCoords doesn't have the same dimensions as n, or ErrNaNCoordinate if any
+// of newCoords are NaN, leaving n where it was, or ErrNodeNotInTree if n isn't in the Tree.
+func (t *Tree) Move(n *Node, newCoords []float64) error {
+	t.Mutex.Lock()
+	defer t.Mutex.Unlock()
+	if n == nil {
+		return errors.New("Can't move a nil node.")
+	}
+	if t.build.noParents {
+		return ErrNoParents
+	}
+	if err := n.checkDimensions(newCoords); err != nil {
+		return err
+	}
+	if hasNaN(newCoords) {
+		return ErrNaNCoordinate
+	}
+	if t.Root == nil || n.root() != t.Root {
+		return ErrNodeNotInTree
+	}
+
+	isLeaf := n.leftChild == nil && n.rightChild == nil
+	if (isLeaf || newCoords[n.axis] == n.Coordinates[n.axis]) && n.fitsAncestors(newCoords) {
+		n.Coordinates = newCoords
+		return nil
+	}
+	if _, err := t.remove(n); err != nil {
+		return err
+	}
+	n.Coordinates = newCoords
+	return t.insert(n)
+}
+
+// Tests whether coords are on the same side of every ancestor's splitting plane as this node.
+func (n *Node) fitsAncestors(coords []float64) bool {
+	child, p := n, n.parent
+	if n.inBucket() {
+		// bucket nodes may be anywhere in their leaf's region
+		child, p = p, p.parent
+	}
+	for ; p != nil; child, p = p, p.parent {
+		if left := coords[p.axis] < p.Coordinates[p.axis]; left != (p.leftChild == child) {
+			return false
+		}
+	}
+	return true
+}
+
+// Removes node n from the Tree. The remaining nodes are rearranged to keep the tree valid,
+// and n is left with no parent or children. Returns ErrNodeNotInTree, leaving both trees unchanged,
+// if n is in another tree or has already been removed.
+//
+// Removal relinks nodes across several levels of the tree, so the Tree is write locked for the
+// whole operation, as it is for Add. Concurrent calls to Remove, and searches alongside them, are
+// safe, and searches never see a partially relinked tree.
+func (t *Tree) Remove(n *Node) error {
+	t.Mutex.Lock()
+	defer t.Mutex.Unlock()
+	_, err := t.remove(n)
+	return err
+}
+
+// Removes node n from the Tree in the same way as Remove, returning the node which took its place:
+// the node with the minimum coordinate on n's axis from n's right subtree, or from its left subtree
+// if it has no right subtree. A leaf with a bucket is replaced by the first node in its bucket, which
+// takes over the rest of the bucket. Returns (nil, nil) if n was a leaf without a bucket, or was in
+// a bucket, so no node was moved.
+func (t *Tree) RemoveNode(n *Node) (replacement *Node, err error) {
+	t.Mutex.Lock()
+	defer t.Mutex.Unlock()
+	return t.remove(n)
+}
+
+// Removes the node at exact coords from the Tree, returning true if a node was removed, or
+// false if no node matching coords was found. Returns (false, error) if len(coords) != tree dimensions.
+// The Tree is locked for both the search and removal, so concurrent removals can't conflict.
+func (t *Tree) RemoveAt(coords []float64) (bool, error) {
+	t.Mutex.Lock()
+	defer t.Mutex.Unlock()
+	if t.Root == nil {
+		return false, nil
+	}
+	n, err := t.Root.find(coords)
+	if err != nil || n == nil {
+		return false, err
+	}
+	if _, err := t.remove(n); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Removes every node in the Tree for which pred returns true, returning the number of nodes removed.
+// The Tree is traversed once, then rebuilt from the remaining nodes as BuildTree does, which is much
+// faster than removing many nodes one at a time, and leaves the tree balanced. Removed nodes are left
+// with no parent or children. pred must not modify the Tree.
+func (t *Tree) RemoveMatching(pred func(*Node) bool) int {
+	t.Mutex.Lock()
+	defer t.Mutex.Unlock()
+	nodes := t.Root.nodeListInto(make([]*Node, 0, t.count))
+	kept := nodes[:0]
+	var removed []*Node
+	for _, n := range nodes {
+		if pred(n) {
+			removed = append(removed, n)
+		} else {
+			kept = append(kept, n)
+		}
+	}
+	if len(removed) == 0 {
+		return 0
+	}
+
+	t.Root = buildRootNode(kept, 0, nil, t.build)
+	t.count = len(kept)
+	for _, n := range removed {
+		n.parent, n.leftChild, n.rightChild, n.bucket = nil, nil, nil, nil
+		n.height = 1
+	}
+	return len(removed)
+}
+
+// Removes the subtree rooted at node n from the Tree, including any nodes in its buckets, and
+// returns it as a new Tree, which has the same settings as the Tree, such as its Metric, and is
+// rebuilt in the same way when balanced. The subtree keeps its shape, so its nodes still split on the same axes as before, and
+// the Tree is left valid without n's subtree. A node in a bucket has no subtree, so is detached on
+// its own. The inverse of adding one Tree's Root to another.
+// Returns an error if n is nil, or ErrNodeNotInTree if it isn't in the Tree.
+func (t *Tree) Detach(n *Node) (*Tree, error) {
+	t.Mutex.Lock()
+	defer t.Mutex.Unlock()
+	if n == nil {
+		return nil, errors.New("Can't detach a nil node.")
+	}
+	if t.build.noParents {
+		return nil, ErrNoParents
+	}
+	if t.Root == nil || n.root() != t.Root {
+		return nil, ErrNodeNotInTree
+	}
+
+	detached := t.emptyCopy()
+	if n.inBucket() {
+		n.remove()
+		detached.count = 1
+	} else {
+		if parent := n.parent; parent == nil {
+			t.Root = nil
+		} else {
+			if parent.leftChild == n {
+				parent.leftChild = nil
+			} else {
+				parent.rightChild = nil
+			}
+			n.parent = nil
+			parent.updateHeights()
+		}
+		n.traverse(func(*Node) {
+			detached.count++
+		})
+	}
+	detached.Root = n
+	t.count -= detached.count
+	return detached, nil
+}
+
+// Removes node n from the Tree, which must already be locked, returning its replacement.
+func (t *Tree) remove(n *Node) (*Node, error) {
+	if n == nil {
+		return nil, errors.New("Can't remove a nil node.")
+	}
+	if t.build.noParents {
+		return nil, ErrNoParents
+	}
+	// following n's parents back to the root costs no more than the removal itself
+	if t.Root == nil || n.root() != t.Root {
+		return nil, ErrNodeNotInTree
+	}
+	replacement := n.remove()
+	if n == t.Root {
+		t.Root = replacement
+	}
+	t.count--
+	return replacement, nil
+}
+
+// Removes this node from its tree, moving another node from its subtree into its place.
+// The replacement is the node with the minimum coordinate on this node's axis from the right
+// subtree, or if there is no right subtree, from the left subtree, which then becomes the right
+// subtree. Both keep every node in the right subtree >= the replacement on this axis.
+// A leaf with a bucket is replaced by the first node in its bucket, and a node in a bucket is
+// just taken out of it. Any bucket this node had is handed on to its replacement.
+// Returns the replacement node, or nil if this node was a leaf or in a bucket.
+func (n *Node) remove() *Node {
+	parent := n.parent
+	if n.inBucket() {
+		for i, b := range parent.bucket {
+			if b == n {
+				parent.bucket = append(parent.bucket[:i], parent.bucket[i+1:]...)
+				break
+			}
+		}
+		if len(parent.bucket) == 0 {
+			parent.bucket = nil
+		}
+		n.parent = nil
+		return nil
+	}
+
+	bucket := n.bucket
+	n.bucket = nil
+	var replacement *Node
+	if n.rightChild != nil {
+		replacement = n.rightChild.findMin(n.axis)
+		replacement.remove()
+	} else if n.leftChild != nil {
+		replacement = n.leftChild.findMin(n.axis)
+		replacement.remove()
+		n.leftChild, n.rightChild = nil, n.leftChild
+	} else if len(bucket) > 0 {
+		replacement, bucket = bucket[0], bucket[1:]
+	}
+
+	if replacement != nil {
+		replacement.axis = n.axis
+		replacement.leftChild, replacement.rightChild = n.leftChild, n.rightChild
+		if replacement.leftChild != nil {
+			replacement.leftChild.parent = replacement
+		}
+		if replacement.rightChild != nil {
+			replacement.rightChild.parent = replacement
+		}
+		replacement.parent = n.parent
+		replacement.bucket = nil
+		for _, b := range bucket {
+			b.parent = replacement
+			replacement.bucket = append(replacement.bucket, b)
+		}
+	}
+	if n.parent != nil {
+		if n.parent.leftChild == n {
+			n.parent.leftChild = replacement
+		} else {
+			n.parent.rightChild = replacement
+		}
+	}
+
+	n.parent, n.leftChild, n.rightChild = nil, nil, nil
+	n.height = 1
+	if replacement != nil {
+		replacement.setHeight()
+	}
+	parent.updateHeights()
+	return replacement
+}
+
+// Sets every node's parent link from the child and bucket links above it, and clears the root's,
+// returning the number of links that were changed. Trees built and changed by this package always
+// have correct parent links, as buildRootNode sets them as it links each subtree, but links broken by
+// changing nodes by hand, which Validate reports, leave Remove, Move and Detach unable to find nodes.
+// Trees built by BuildTreeNoParent are repaired by clearing every parent link instead.
+func (t *Tree) FixParents() int {
+	t.Mutex.Lock()
+	defer t.Mutex.Unlock()
+	return t.Root.fixParents(nil, !t.build.noParents)
+}
+
+// Links every node in this (sub)tree to its parent, starting with this node linked to parent, or
+// unlinks them all if link is false. Returns the number of links changed.
+func (n *Node) fixParents(parent *Node, link bool) int {
+	if n == nil {
+		return 0
+	}
+	if !link {
+		parent = nil
+	}
+	changed := 0
+	if n.parent != parent {
+		n.parent = parent
+		changed++
+	}
+	for _, b := range n.bucket {
+		changed += b.fixParents(n, link)
+	}
+	return changed + n.leftChild.fixParents(n, link) + n.rightChild.fixParents(n, link)
+}
+
 // Rebalances a whole Tree.
 func (t *Tree) Balance() {
 	t.Mutex.Lock()
 	defer t.Mutex.Unlock()
-	nodelist := t.Root.nodeList()
-	t.Root = buildRootNode(nodelist, 0, nil)
+	nodelist := t.Root.nodeListInto(make([]*Node, 0, t.count))
+	t.Root = buildRootNode(nodelist, 0, nil, t.build)
+}
+
+
+// Checks the k-d tree invariant: for every node, every node in its left subtree has a coordinate
+// on the node's axis less than the node's, and every node in its right subtree has a coordinate
+// on that axis greater than or equal to the node's. Also checks that each node's parent link points
+// to the node it's a child of, and that the root has no parent, or for trees built by
+// BuildTreeNoParent, that no node has a parent. This catches nodes whose Coordinates were changed
+// in place, which searches would otherwise route past without finding.
+//
+// Returns nil if the Tree is valid, ErrDimensionMismatch if nodes have differing dimensions,
+// or an error naming the first misplaced node found, the axis, and the coordinates compared.
+func (t *Tree) Validate() error {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	if t.Root == nil {
+		return nil
+	}
+	if t.Root.parent != nil {
+		return errors.New("Root " + t.Root.String() + " has parent " + t.Root.parent.String())
+	}
+	dims := len(t.Root.Coordinates)
+	return t.Root.validate(make([]*Node, dims), make([]*Node, dims), !t.build.noParents)
+}
+
+// Checks the k-d tree invariant and parent links for this (sub)tree. lower[a] is the nearest
+// ancestor this subtree is to the right of on axis a, so every coordinate on a must be >= its
+// coordinate, and upper[a] is the nearest ancestor it's to the left of, so every coordinate on a
+// must be < its coordinate. Either is nil if there's no such ancestor. If parents is false, nodes
+// must have no parent links rather than links to their parents.
+func (n *Node) validate(lower, upper []*Node, parents bool) error {
+	if n == nil {
+		return nil
+	}
+	if len(n.Coordinates) != len(lower) {
+		return ErrDimensionMismatch
+	}
+	for a, l := range lower {
+		if l != nil && n.Coordinates[a] < l.Coordinates[a] {
+			return errors.New("Node " + n.String() + " is in the right subtree of " + l.String() + ", but on axis " +
+				strconv.Itoa(a) + " its coordinate " + strconv.FormatFloat(n.Coordinates[a], 'G', -1, 64) +
+				" is less than " + strconv.FormatFloat(l.Coordinates[a], 'G', -1, 64))
+		}
+	}
+	for a, u := range upper {
+		if u != nil && n.Coordinates[a] >= u.Coordinates[a] {
+			return errors.New("Node " + n.String() + " is in the left subtree of " + u.String() + ", but on axis " +
+				strconv.Itoa(a) + " its coordinate " + strconv.FormatFloat(n.Coordinates[a], 'G', -1, 64) +
+				" is not less than " + strconv.FormatFloat(u.Coordinates[a], 'G', -1, 64))
+		}
+	}
+	for _, b := range n.bucket {
+		if !parents && b.parent != nil {
+			return errors.New("Node " + b.String() + " is in the bucket of " + n.String() + ", but has a parent")
+		} else if parents && b.parent != n {
+			return errors.New("Node " + b.String() + " is in the bucket of " + n.String() + ", but isn't its child")
+		}
+		// bucket nodes must be in this node's region, but can be on either side of its split
+		if err := b.validate(lower, upper, parents); err != nil {
+			return err
+		}
+	}
+	for _, child := range []*Node{n.leftChild, n.rightChild} {
+		if child == nil {
+			continue
+		}
+		if !parents && child.parent != nil {
+			return errors.New("Node " + child.String() + " is a child of " + n.String() + ", but has a parent")
+		} else if parents && child.parent == nil {
+			return errors.New("Node " + child.String() + " is a child of " + n.String() + ", but has no parent")
+		} else if parents && child.parent != n {
+			return errors.New("Node " + child.String() + " is a child of " + n.String() + ", but its parent is " + child.parent.String())
+		}
+	}
+
+	// narrow the bounds on this node's axis for each subtree, then restore them
+	old := upper[n.axis]
+	upper[n.axis] = n
+	err := n.leftChild.validate(lower, upper, parents)
+	upper[n.axis] = old
+	if err != nil {
+		return err
+	}
+	old = lower[n.axis]
+	lower[n.axis] = n
+	err = n.rightChild.validate(lower, upper, parents)
+	lower[n.axis] = old
+	return err
+}
+
+// Rebalances only the subtree of the Tree rooted at node n, which is much cheaper than Balance when
+// only one branch has become lopsided. The rebuilt subtree starts splitting on n's axis, and
+// takes n's place under its parent. Nodes that aren't in this Tree are ignored. In trees built by
+// BuildTreeNoParent only the root can be found, which rebalances the whole Tree.
+func (t *Tree) RebalanceSubtree(n *Node) {
+	t.Mutex.Lock()
+	defer t.Mutex.Unlock()
+	if n == nil || t.Root == nil || n.root() != t.Root || n.inBucket() {
+		return
+	}
+	t.rebuild(n)
 }
 
+// Rebuilds the subtree of the Tree rooted at node n, which must not be in a bucket, in n's place.
+// The Tree must already be locked.
+func (t *Tree) rebuild(n *Node) {
+	parent := n.parent
+	isLeft := parent != nil && parent.leftChild == n
+	// without a strategy, buildRootNode splits on depth % dimensions, so starting at depth n.axis keeps n's axis
+	subtree := buildRootNode(n.nodeList(), n.axis, parent, t.build)
+	switch {
+	case parent == nil:
+		t.Root = subtree
+	case isLeft:
+		parent.leftChild = subtree
+	default:
+		parent.rightChild = subtree
+	}
+	parent.updateHeights()
+}
 
-// Returns Depth of the deepest branch of this Tree.
+// Returns Depth of the deepest branch of this Tree. Every node keeps the height of its subtree
+// up to date as the tree changes, so this doesn't need to walk the tree.
 func (t *Tree) Depth() int {
 	t.Mutex.RLock()
 	defer t.Mutex.RUnlock()
-	return t.Root.depth()
+	if t.Root == nil {
+		return 0
+	}
+	return t.Root.height
+}
+
+// Sets this node's height from the heights of its children.
+func (n *Node) setHeight() {
+	n.height = 1
+	for _, child := range []*Node{n.leftChild, n.rightChild} {
+		if child != nil && child.height+1 > n.height {
+			n.height = child.height + 1
+		}
+	}
+}
+
+// Updates the height of this node and its ancestors after its children have changed, stopping at
+// the first one whose height is unchanged. Does nothing if n is nil.
+func (n *Node) updateHeights() {
+	for changed := true; n != nil && changed; n = n.parent {
+		old := n.height
+		n.setHeight()
+		changed = n.height != old
+	}
 }
 
-// Returns depth of the deepest branch of this (sub)tree.
+// Sets the height of every node in this (sub)tree by walking it, for trees linked up without
+// tracking heights, such as decoded ones.
+func (n *Node) setHeights() {
+	n.traverse(func(n *Node) {
+		n.setHeight()
+	})
+}
+
+// Counts the depth of this (sub)tree by walking it, ignoring the cached heights.
 func (n *Node) depth() int {
 	if n == nil {
 		return 0
@@ -123,14 +1054,67 @@ func (n *Node) depth() int {
 	return right_depth
 }
 
-// Returns number of nodes in the Tree.
+// Returns number of nodes in the Tree. The count is kept up to date as nodes are added and
+// removed, so this doesn't need to walk the tree.
 func (t *Tree) Size() int {
 	t.Mutex.RLock()
 	defer t.Mutex.RUnlock()
-	return t.Root.size()
+	return t.count
 }
 
-// Returns number of nodes in this (sub)tree.
+// Returns number of nodes in this (sub)tree, counting them without building a list of them.
 func (n *Node) size() int {
-	return len(n.nodeList())
+	if n == nil {
+		return 0
+	}
+	return 1 + len(n.bucket) + n.leftChild.size() + n.rightChild.size()
+}
+
+// Statistics describing the shape of a Tree, returned by Tree.Stats.
+type TreeStats struct {
+	Size         int     // number of nodes
+	MaxDepth     int     // depth of the deepest branch, as returned by Tree.Depth
+	MinLeafDepth int     // depth of the shallowest leaf
+	AvgLeafDepth float64 // mean depth of all leaves
+
+	// MaxDepth divided by the depth of a perfectly balanced tree with the same number of nodes,
+	// ceil(log2(Size+1)). This is 1 for a balanced tree, and grows as the tree becomes more skewed.
+	BalanceFactor float64
+}
+
+// Returns statistics describing the shape of the Tree, measured in a single traversal.
+// The root is at depth 1, and all statistics are 0 for an empty tree.
+func (t *Tree) Stats() TreeStats {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+
+	var stats TreeStats
+	if t.Root == nil {
+		return stats
+	}
+	leaves, leafDepths := 0, 0
+	t.Root.stats(1, &stats, &leaves, &leafDepths)
+	stats.AvgLeafDepth = float64(leafDepths) / float64(leaves)
+	stats.BalanceFactor = float64(stats.MaxDepth) / math.Ceil(math.Log2(float64(stats.Size+1)))
+	return stats
+}
+
+// Adds this (sub)tree at depth to stats, and counts its leaves and their total depth.
+func (n *Node) stats(depth int, stats *TreeStats, leaves, leafDepths *int) {
+	if n == nil {
+		return
+	}
+	stats.Size += 1 + len(n.bucket)
+	if depth > stats.MaxDepth {
+		stats.MaxDepth = depth
+	}
+	if n.leftChild == nil && n.rightChild == nil {
+		if *leaves == 0 || depth < stats.MinLeafDepth {
+			stats.MinLeafDepth = depth
+		}
+		*leaves++
+		*leafDepths += depth
+	}
+	n.leftChild.stats(depth+1, stats, leaves, leafDepths)
+	n.rightChild.stats(depth+1, stats, leaves, leafDepths)
 }
@@ -0,0 +1,281 @@
+// Copyright 2012 by Graeme Humphries <graeme@sudo.ca>
+//
+// kdtree is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// kdtree is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with kdtree.  If not, see http://www.gnu.org/licenses/.
+
+package kdtree
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"sync"
+)
+
+/***** Typed Coordinate Trees *****/
+
+// Coordinate types a TypedTree can hold.
+type Numeric interface {
+	~int | ~int32 | ~int64 | ~float32 | ~float64
+}
+
+// Node of a TypedTree, with coordinates of type T. Integer coordinates compare exactly, so
+// Find is reliable for grid positions, and int32 coordinates take half the memory of float64.
+type TypedNode[T Numeric] struct {
+	Value interface{} // user data associated with this node's coordinates
+
+	// Axis for plane of bisection for this node, determined when added to a tree.
+	axis        int
+	Coordinates []T
+	leftChild   *TypedNode[T] // Nodes < Location on this axis.
+	rightChild  *TypedNode[T] // Nodes >= Location on this axis.
+	parent      *TypedNode[T] // nil for the root of a tree.
+}
+
+// Create a new typed node from a set of coordinates. The node has len(coords) dimensions,
+// and keeps coords rather than copying it.
+func NewTypedNode[T Numeric](coords []T) *TypedNode[T] {
+	return &TypedNode[T]{Coordinates: coords}
+}
+
+// k-d tree of TypedNodes, split and searched by the same rules as Tree: each node splits its
+// subtree at the median along axis depth % dimensions, and nodes equal to the split go right.
+//
+// Its nodes are linked by their own methods rather than Tree's, which are written for float64
+// coordinates to keep them fast, but it chooses medians with the same selectKeyed as Tree and tests
+// ranges with the same TypedRange methods Range uses. It only supports BuildTypedTree, Add, Find and
+// FindRange. It has no Remove, Move, Validate or Balance, no buckets, build strategies
+// or Metrics, and no nearest neighbor or radius searches; use a Tree for any of those.
+type TypedTree[T Numeric] struct {
+	Mutex sync.RWMutex
+
+	Root *TypedNode[T]
+}
+
+// Range parameter with typed bounds, used to search a TypedTree. It matches coordinates in the same
+// way as Range, which has the same fields, and whose methods are written in terms of TypedRange's.
+type TypedRange[T Numeric] struct {
+	Min T
+	Max T
+}
+
+// Tests whether both of the range's bounds are infinite, in opposite directions, so it matches
+// every coordinate. Integer bounds are never infinite.
+func (r TypedRange[T]) unbounded() bool {
+	return math.IsInf(float64(r.Min), 0) && math.IsInf(float64(r.Max), 0) && r.Min != r.Max
+}
+
+// Tests whether coordinate c is within the range. Max is only inclusive if maxInclusive is true,
+// or if it's +Inf, as nothing is beyond it.
+func (r TypedRange[T]) contains(c T, maxInclusive bool) bool {
+	if r.unbounded() {
+		return true
+	}
+	return !(c < r.Min || c > r.Max || (c == r.Max && !maxInclusive && !math.IsInf(float64(r.Max), 1)))
+}
+
+// Tests whether the range can hold coordinates less than split, so the left subtree of a node
+// splitting at split must be searched.
+func (r TypedRange[T]) reachesLeft(split T) bool {
+	return r.unbounded() || r.Min < split
+}
+
+// Tests whether the range can hold coordinates >= split, so the right subtree of a node splitting
+// at split must be searched.
+func (r TypedRange[T]) reachesRight(split T, maxInclusive bool) bool {
+	return r.unbounded() || r.Max > split || (r.Max == split && r.contains(split, maxInclusive))
+}
+
+// Returns an error if axis is outside of dimensions, or r has a NaN bound, for Tree and TypedTree
+// range searches.
+func checkTypedRange[T Numeric](axis int, r TypedRange[T], dimensions int) error {
+	if axis >= dimensions {
+		return errors.New("Range on axis " + strconv.Itoa(axis) + " exceeds tree dimensions.")
+	}
+	if axis < 0 {
+		return errors.New("Negative axes are invalid.")
+	}
+	if r.Min != r.Min || r.Max != r.Max {
+		return errors.New("Range on axis " + strconv.Itoa(axis) + " has a NaN bound.")
+	}
+	return nil
+}
+
+// Builds a balanced TypedTree from nodes, choosing the same medians as BuildTree, without reordering
+// the caller's list. Panics with ErrDimensionMismatch if nodes don't all have the same dimensions, or
+// ErrNaNCoordinate if any of a float type's coordinates are NaN.
+func BuildTypedTree[T Numeric](nodes []*TypedNode[T]) *TypedTree[T] {
+	for _, n := range nodes {
+		if len(n.Coordinates) != len(nodes[0].Coordinates) {
+			panic(ErrDimensionMismatch)
+		}
+		if hasNaNTyped(n.Coordinates) {
+			panic(ErrNaNCoordinate)
+		}
+	}
+	return &TypedTree[T]{Root: buildTypedNode(append([]*TypedNode[T](nil), nodes...), make([]T, len(nodes)), 0, nil)}
+}
+
+// Generic counterpart of buildNode: splits nodes on the median along axis depth % dimensions,
+// keeping nodes with the same value as the split out of the left subtree. nodes is partitioned in
+// place, as buildRootNode does, choosing medians with the same selectKeyed, using keys, at least as
+// long as nodes, as scratch space.
+func buildTypedNode[T Numeric](nodes []*TypedNode[T], keys []T, depth int, parent *TypedNode[T]) *TypedNode[T] {
+	if len(nodes) == 0 {
+		return nil
+	}
+	axis := depth % len(nodes[0].Coordinates)
+	keys = keys[:len(nodes)]
+	for i, n := range nodes {
+		keys[i] = n.Coordinates[axis]
+	}
+	median := selectKeyed(keys, nodes, (len(nodes)/2)-1)
+
+	root := nodes[median]
+	root.parent = parent
+	root.axis = axis
+	root.leftChild = buildTypedNode(nodes[:median], keys[:median], depth+1, root)
+	root.rightChild = buildTypedNode(nodes[median+1:], keys[median+1:], depth+1, root)
+	return root
+}
+
+// Adds n to the TypedTree as a leaf, in the same way as Tree.Add. Returns ErrDimensionMismatch if n
+// doesn't have the same dimensions as the tree, or ErrNaNCoordinate if any of a float type's
+// coordinates are NaN.
+func (t *TypedTree[T]) Add(n *TypedNode[T]) error {
+	t.Mutex.Lock()
+	defer t.Mutex.Unlock()
+	if hasNaNTyped(n.Coordinates) {
+		return ErrNaNCoordinate
+	}
+	n.leftChild, n.rightChild = nil, nil
+	if t.Root == nil {
+		n.axis, n.parent = 0, nil
+		t.Root = n
+		return nil
+	}
+	if len(n.Coordinates) != len(t.Root.Coordinates) {
+		return ErrDimensionMismatch
+	}
+
+	parent := t.Root
+	for {
+		next := &parent.rightChild
+		if n.Coordinates[parent.axis] < parent.Coordinates[parent.axis] {
+			next = &parent.leftChild
+		}
+		if *next == nil {
+			*next = n
+			break
+		}
+		parent = *next
+	}
+	n.axis = (parent.axis + 1) % len(n.Coordinates)
+	n.parent = parent
+	return nil
+}
+
+// Searches TypedTree for node at exact coords. Returns (nil, nil) if no node matching coords found,
+// or (nil, error) if len(coords) != tree dimensions.
+func (t *TypedTree[T]) Find(coords []T) (*TypedNode[T], error) {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	if t.Root == nil {
+		return nil, nil
+	}
+	if len(coords) != len(t.Root.Coordinates) {
+		return nil, errors.New("Search coordinates have " + strconv.Itoa(len(coords)) + " dimensions, tree has " + strconv.Itoa(len(t.Root.Coordinates)) + " dimensions.")
+	}
+
+	for n := t.Root; n != nil; {
+		if coords[n.axis] < n.Coordinates[n.axis] {
+			n = n.leftChild
+			continue
+		}
+		if equalTyped(coords, n.Coordinates) {
+			return n, nil
+		}
+		n = n.rightChild
+	}
+	return nil, nil
+}
+
+// Find a list of TypedNodes in TypedTree matching the supplied map of dimensional TypedRanges,
+// in the same way as Tree.FindRange. Both Min and Max are inclusive, and either may be infinite.
+//
+// If no results are found, (nil, nil) is returned.
+// If an axis outside of the tree's dimensions is specified, or a TypedRange has a NaN bound, nil is
+// returned with an error.
+func (t *TypedTree[T]) FindRange(ranges map[int]TypedRange[T]) ([]*TypedNode[T], error) {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	if t.Root == nil {
+		return nil, nil
+	}
+	for a, r := range ranges {
+		if err := checkTypedRange(a, r, len(t.Root.Coordinates)); err != nil {
+			return nil, err
+		}
+	}
+	return t.Root.findRange(ranges, nil), nil
+}
+
+// Appends the nodes in (sub)tree matching ranges to result, searching the same subtrees as
+// Node.appendRange, with the same TypedRange tests. Ranges must already be checked.
+func (n *TypedNode[T]) findRange(ranges map[int]TypedRange[T], result []*TypedNode[T]) []*TypedNode[T] {
+	if n == nil {
+		return result
+	}
+
+	add := true
+	for a, r := range ranges {
+		if !r.contains(n.Coordinates[a], true) {
+			add = false
+			break
+		}
+	}
+	if add {
+		result = append(result, n)
+	}
+	r, ok := ranges[n.axis]
+	if !ok || r.reachesLeft(n.Coordinates[n.axis]) {
+		result = n.leftChild.findRange(ranges, result)
+	}
+	if !ok || r.reachesRight(n.Coordinates[n.axis], true) {
+		result = n.rightChild.findRange(ranges, result)
+	}
+	return result
+}
+
+// Tests whether any of coords are NaN, which only float types can hold, as hasNaN does for float64.
+func hasNaNTyped[T Numeric](coords []T) bool {
+	for _, c := range coords {
+		if c != c {
+			return true
+		}
+	}
+	return false
+}
+
+// Tests equality of typed coordinate slices, as equal_fl does for float64.
+func equalTyped[T Numeric](a, b []T) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
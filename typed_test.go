@@ -0,0 +1,134 @@
+// Copyright 2012 by Graeme Humphries <graeme@sudo.ca>
+//
+// kdtree is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// kdtree is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with kdtree.  If not, see http://www.gnu.org/licenses/.
+
+package kdtree
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// Generate a random list of typed nodes on an integer grid, with many duplicate coordinates.
+func genTypedList(dimensions, size, grid int) []*TypedNode[int32] {
+	nodelist := make([]*TypedNode[int32], size)
+	for i := range nodelist {
+		coords := make([]int32, dimensions)
+		for j := range coords {
+			coords[j] = int32(rand.Intn(grid))
+		}
+		nodelist[i] = NewTypedNode(coords)
+	}
+	return nodelist
+}
+
+func TestTypedTree(t *testing.T) {
+	nl := genTypedList(3, 20000, 100)
+	tree := BuildTypedTree(nl[:10000])
+	for _, n := range nl[10000:] {
+		if err := tree.Add(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, n := range nl {
+		if found, err := tree.Find(n.Coordinates); err != nil {
+			t.Fatal("Error while searching tree:", err)
+		} else if found == nil || !equalTyped(found.Coordinates, n.Coordinates) {
+			t.Fatal("Node at", n.Coordinates, "not found!")
+		}
+	}
+	if found, err := tree.Find([]int32{-1, 0, 0}); found != nil || err != nil {
+		t.Fatal("Searching for missing coordinates should return (nil, nil), got", found, err)
+	}
+	if _, err := tree.Find([]int32{0, 0}); err == nil {
+		t.Fatal("Searching with the wrong number of dimensions should return an error.")
+	}
+
+	ranges := map[int]TypedRange[int32]{0: {10, 20}, 2: {50, 50}}
+	results, err := tree.FindRange(ranges)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := 0
+	for _, n := range nl {
+		if n.Coordinates[0] >= 10 && n.Coordinates[0] <= 20 && n.Coordinates[2] == 50 {
+			expected++
+		}
+	}
+	if len(results) != expected {
+		t.Fatal("FindRange returned", len(results), "nodes, expected", expected)
+	}
+	if _, err := tree.FindRange(map[int]TypedRange[int32]{3: {0, 1}}); err == nil {
+		t.Fatal("Searching with an invalid axis should return an error.")
+	}
+
+	if err := tree.Add(NewTypedNode([]int32{1, 2})); err != ErrDimensionMismatch {
+		t.Fatal("Adding a node with the wrong dimensions should return ErrDimensionMismatch, got", err)
+	}
+}
+
+// Typed trees should choose the same medians as BuildTree, so with distinct coordinates both trees
+// have the same shape, should find the same ranges, and should reject NaN coordinates.
+func TestTypedTreeShape(t *testing.T) {
+	nl := genlist(3, 5000)
+	typed := make([]*TypedNode[float64], len(nl))
+	for i, n := range nl {
+		typed[i] = NewTypedNode(append([]float64(nil), n.Coordinates...))
+	}
+	order := append([]*TypedNode[float64](nil), typed...)
+	tree, typedTree := BuildTree(nl), BuildTypedTree(typed)
+	for i := range typed {
+		if typed[i] != order[i] {
+			t.Fatal("BuildTypedTree reordered the node list at index", i)
+		}
+	}
+
+	var sameShape func(n *Node, tn *TypedNode[float64]) bool
+	sameShape = func(n *Node, tn *TypedNode[float64]) bool {
+		if n == nil || tn == nil {
+			return n == nil && tn == nil
+		}
+		return equal_fl(n.Coordinates, tn.Coordinates) && n.axis == tn.axis &&
+			sameShape(n.leftChild, tn.leftChild) && sameShape(n.rightChild, tn.rightChild)
+	}
+	if !sameShape(tree.Root, typedTree.Root) {
+		t.Fatal("TypedTree has a different shape to the Tree built from the same coordinates.")
+	}
+
+	// ranges are tested in the same way as Tree's, including infinite bounds
+	ranges := map[int]Range{0: {math.Inf(-1), 0.5}, 2: {math.Inf(1), math.Inf(-1)}}
+	typedRanges := map[int]TypedRange[float64]{}
+	for a, r := range ranges {
+		typedRanges[a] = TypedRange[float64](r)
+	}
+	expected, _ := tree.FindRange(ranges)
+	if results, err := typedTree.FindRange(typedRanges); err != nil || len(results) != len(expected) {
+		t.Fatal("TypedTree.FindRange returned", len(results), "nodes, Tree.FindRange returned", len(expected), "error", err)
+	}
+	if _, err := typedTree.FindRange(map[int]TypedRange[float64]{0: {math.NaN(), 1}}); err == nil {
+		t.Fatal("Searching with a NaN bound should return an error.")
+	}
+
+	if err := typedTree.Add(NewTypedNode([]float64{1, math.NaN(), 2})); err != ErrNaNCoordinate {
+		t.Fatal("Adding a node with a NaN coordinate should return ErrNaNCoordinate, got", err)
+	}
+	defer func() {
+		if r := recover(); r != ErrNaNCoordinate {
+			t.Fatal("Building a tree with a NaN coordinate should panic with ErrNaNCoordinate, got", r)
+		}
+	}()
+	BuildTypedTree([]*TypedNode[float64]{NewTypedNode([]float64{math.NaN()})})
+}